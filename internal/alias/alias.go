@@ -0,0 +1,253 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package alias gives names and tags to the devices which send syslog entries, so
+// that "192.168.1.49" can be shown, filtered and filed as "kitchen-relay"
+package alias
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/filter"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// An Alias names the devices from one source. Aliases are written as
+//
+//	SOURCE NAME [TAG...]
+//
+// where SOURCE is a filter.Source such as 192.168.1.49, 10.0.0.0/24 or a hostname
+// such as ESP_3A4F* (host:NAME must be used for a hostname which looks like an
+// address). Tags are separated by spaces or commas.
+type Alias struct {
+	text   string
+	source *filter.Source
+	name   string
+	tags   []string
+}
+
+// Parse parses an alias such as "192.168.1.49 kitchen-relay shelly,kitchen"
+func Parse(s string) (*Alias, error) {
+	self := &Alias{text: strings.TrimSpace(s)}
+	words := strings.FieldsFunc(self.text, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+	if len(words) < 2 {
+		return nil, fmt.Errorf("Alias \"%s\" should be SOURCE NAME [TAG...]", s)
+	}
+	self.name = words[1]
+	self.tags = words[2:]
+	var err error
+	if self.source, err = filter.ParseSource(words[0]); err != nil {
+		return nil, fmt.Errorf("Alias \"%s\": %w", s, err)
+	}
+	return self, nil
+}
+
+func (self *Alias) String() string {
+	return self.text
+}
+
+// Name returns the name given to the source
+func (self *Alias) Name() string {
+	return self.name
+}
+
+// Tags returns the tags given to the source
+func (self *Alias) Tags() []string {
+	return self.tags
+}
+
+// Applies returns true if the entry comes from the alias's source
+func (self *Alias) Applies(e *syslog.Entry) bool {
+	return self.source.Matches(e)
+}
+
+// Table is a list of aliases in which the first alias to apply to an entry is used
+type Table struct {
+	aliases []*Alias
+}
+
+// Add parses an alias and adds it to the end of the table
+func (self *Table) Add(s string) error {
+	a, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	self.aliases = append(self.aliases, a)
+	return nil
+}
+
+// Read adds the aliases in a file, one per line. Blank lines and lines starting
+// with # are ignored; name is used in error messages.
+func (self *Table) Read(r io.Reader, name string) error {
+	return filter.ReadLines(r, name, self.Add)
+}
+
+// Len returns the number of aliases
+func (self *Table) Len() int {
+	if self == nil {
+		return 0
+	}
+	return len(self.aliases)
+}
+
+// Find returns the first alias which applies to the entry, or nil if there isn't one
+func (self *Table) Find(e *syslog.Entry) *Alias {
+	if self == nil {
+		return nil
+	}
+	for _, a := range self.aliases {
+		if a.Applies(e) {
+			return a
+		}
+	}
+	return nil
+}
+
+// Apply records the name and tags of the entry's alias (if any) in the entry
+func (self *Table) Apply(e *syslog.Entry) {
+	if a := self.Find(e); a != nil {
+		e.SetAlias(a.name, a.tags)
+	}
+}
+
+// Current holds a Table which can be replaced while it is being used
+type Current struct {
+	table atomic.Pointer[Table]
+}
+
+// Store replaces the aliases in use
+func (self *Current) Store(t *Table) {
+	self.table.Store(t)
+}
+
+// Table returns the aliases currently in use
+func (self *Current) Table() *Table {
+	if self == nil {
+		return nil
+	}
+	return self.table.Load()
+}
+
+// Len returns the number of aliases currently in use
+func (self *Current) Len() int {
+	return self.Table().Len()
+}
+
+// Apply records the name and tags of the entry's alias (if any) in the entry
+func (self *Current) Apply(e *syslog.Entry) {
+	self.Table().Apply(e)
+}
+
+// File is a Table loaded from a file which is reloaded when it changes
+//
+// If a reloaded file has errors, the previous aliases continue to be used.
+type File struct {
+	Current
+	name    string
+	onError func(error)
+
+	mu      sync.Mutex // Serialises reloads
+	modTime time.Time
+	size    int64
+	stop    chan struct{}
+}
+
+// Open loads the aliases in a file. If onError is not nil, it is called with
+// any errors found when the file is reloaded after a change.
+func Open(name string, onError func(error)) (*File, error) {
+	self := &File{name: name, onError: onError, stop: make(chan struct{})}
+	if err := self.Reload(); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+// Name returns the name of the file
+func (self *File) Name() string {
+	return self.name
+}
+
+// Reload reads the file again
+func (self *File) Reload() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	f, err := os.Open(self.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// Remember the version of the file even if it has errors, so it isn't
+	// reported again until it is changed
+	self.modTime, self.size = info.ModTime(), info.Size()
+	t := &Table{}
+	if err := t.Read(f, self.name); err != nil {
+		return err
+	}
+	self.Store(t)
+	return nil
+}
+
+// Watch checks every interval whether the file has changed, and if it has reloads it
+func (self *File) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-self.stop:
+				return
+			case <-ticker.C:
+				if self.changed() {
+					self.report(self.Reload())
+				}
+			}
+		}
+	}()
+}
+
+// changed returns true if the file's size or modification time have changed since
+// it was last loaded
+func (self *File) changed() bool {
+	info, err := os.Stat(self.name)
+	if err != nil {
+		return false // Probably being replaced: keep using the current aliases
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return !info.ModTime().Equal(self.modTime) || info.Size() != self.size
+}
+
+func (self *File) report(err error) {
+	if err != nil && self.onError != nil {
+		self.onError(fmt.Errorf("could not reload aliases: %w", err))
+	}
+}
+
+// Close stops watching the file
+func (self *File) Close() error {
+	if self != nil {
+		close(self.stop)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alias_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/alias"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func entry(ip, raw string) *syslog.Entry {
+	addr, _ := net.ResolveUDPAddr("udp", net.JoinHostPort(ip, "514"))
+	return syslog.NewEntry([]byte(raw), addr)
+}
+
+func TestTable(t *testing.T) {
+	table := &alias.Table{}
+	err := table.Read(strings.NewReader(`
+# Devices
+192.168.1.49 kitchen-relay shelly,kitchen
+esp_3a4f* sensor iot
+host:10.9.9.9 odd-name
+192.168.1.0/24   lan
+`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Len() != 4 {
+		t.Errorf("got %d aliases", table.Len())
+	}
+	for _, ex := range []struct {
+		e    *syslog.Entry
+		name string
+		tags string
+	}{
+		{entry("192.168.1.49", "hello"), "kitchen-relay", "shelly kitchen"},
+		{entry("::ffff:192.168.1.50", "hello"), "lan", ""},
+		{entry("10.0.0.1", "<14>1 2022-06-06T13:44:58Z ESP_3A4F1C app - - - hello"), "sensor", "iot"},
+		{entry("10.0.0.1", "<14>1 2022-06-06T13:44:58Z 10.9.9.9 app - - - hello"), "odd-name", ""},
+		{entry("10.0.0.1", "<14>1 2022-06-06T13:44:58Z ESP_3B app - - - hello"), "", ""},
+	} {
+		table.Apply(ex.e)
+		if ex.e.Alias() != ex.name || strings.Join(ex.e.Tags(), " ") != ex.tags {
+			t.Errorf("%s: got %q %v, wanted %q %q", ex.e, ex.e.Alias(), ex.e.Tags(), ex.name, ex.tags)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{"192.168.1.49", "10.0.0.0/33 net", "host: x", "[a sensor", "10.0.0.300 x", "10.0.0.5:514 x"} {
+		if _, err := alias.Parse(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+	err := (&alias.Table{}).Read(strings.NewReader("10.0.0.1 a\n\nbad\n"), "aliases.txt")
+	if err == nil || !strings.HasPrefix(err.Error(), "aliases.txt:3:") {
+		t.Errorf("expected an error with a line number, got %v", err)
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "aliases")
+	if err := os.WriteFile(name, []byte("10.0.0.1 first\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	errors := make(chan error, 10)
+	f, err := alias.Open(name, func(err error) { errors <- err })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Watch(10 * time.Millisecond)
+
+	check := func(wanted string) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			e := entry("10.0.0.1", "hello")
+			f.Apply(e)
+			if e.Alias() == wanted {
+				return
+			}
+		}
+		t.Errorf("alias was not %q", wanted)
+	}
+	check("first")
+
+	if err := os.WriteFile(name, []byte("10.0.0.1 second\n10.0.0.2 other\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	check("second")
+
+	// A file with errors is reported and the previous aliases are kept
+	if err := os.WriteFile(name, []byte("10.0.0.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errors:
+	case <-time.After(2 * time.Second):
+		t.Error("expected a reload error")
+	}
+	check("second")
+
+	if err := os.WriteFile(name, []byte("10.0.0.1 third\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	check("third")
+	if f.Len() != 1 {
+		t.Errorf("got %d aliases", f.Len())
+	}
+}
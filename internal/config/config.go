@@ -0,0 +1,130 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config reads configuration files written in a subset of TOML, in which
+// each key names a command line option:
+//
+//	# Options before the first section are named by their keys
+//	listen = ["udp://:514", "tls://:6514"]
+//	severity = "info"
+//
+//	# Keys in a section are prefixed with the section name, so this sets -rotate-size
+//	[rotate]
+//	size = "100M"
+//	gzip = true
+//
+// Values are strings ("basic" with escapes, 'literal', and triple-quoted multi-line),
+// integers, floats, booleans, or arrays of these. Dotted keys, inline tables, arrays
+// of tables and dates are not supported.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A Setting is a key and its value
+type Setting struct {
+	File    string   // Name of the file, for error messages
+	Line    int      // Line number of the key
+	Section string   // "" before the first section
+	Key     string   // As written, without quotes
+	Values  []string // One value, or the elements of an array
+	IsArray bool
+}
+
+// FlagName is the name of the command line option set by the setting: the key,
+// prefixed by the section name and a hyphen if it is in a section
+func (self *Setting) FlagName() string {
+	if self.Section == "" {
+		return self.Key
+	}
+	return self.Section + "-" + self.Key
+}
+
+// Errorf returns an error which includes the file name and line number of the setting
+func (self *Setting) Errorf(format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", self.File, self.Line, fmt.Sprintf(format, args...))
+}
+
+// Wrap adds the file name and line number of the setting to an error, returning nil
+// if err is nil
+func (self *Setting) Wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	return self.Errorf("%s", err)
+}
+
+// A Repeatable flag.Value can be given more than once. Each element of an array is
+// set separately for a Repeatable flag, and the elements are joined with commas for
+// other flags.
+type Repeatable interface {
+	flag.Value
+	IsRepeatable() bool
+}
+
+// SetFlag sets the command line option named by the setting in fs, after passing
+// each value to check (if it isn't nil) so that errors can be reported with the line
+// number rather than when the option is used
+func (self *Setting) SetFlag(fs *flag.FlagSet, check func(name, value string) error) error {
+	name := self.FlagName()
+	f := fs.Lookup(name)
+	if f == nil {
+		return self.Errorf("unknown option %s", name)
+	}
+	values := self.Values
+	if r, ok := f.Value.(Repeatable); !ok || !r.IsRepeatable() {
+		values = []string{strings.Join(values, ",")}
+	}
+	for _, v := range values {
+		if check != nil {
+			if err := check(name, v); err != nil {
+				return self.Errorf("%s: %s", name, err)
+			}
+		}
+		if err := fs.Set(name, v); err != nil {
+			return self.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// File is the settings in a configuration file, in the order they were written
+type File struct {
+	Name     string
+	Settings []*Setting
+}
+
+// Load reads and parses a configuration file
+func Load(name string) (*File, error) {
+	text, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(text), name)
+}
+
+// Section returns the settings in a section
+func (self *File) Section(name string) []*Setting {
+	var settings []*Setting
+	for _, s := range self.Settings {
+		if s.Section == name {
+			settings = append(settings, s)
+		}
+	}
+	return settings
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/m-z-b/syslogqd/internal/config"
+)
+
+const example = `# Example
+listen = [
+	"udp://:514",   # UDP
+	'tls://:6514',
+]
+severity = "info"
+quiet = true
+queue-size = 1_000
+rate = 2.5
+filter = """
+severity <= warning &&
+  text ~ "fail\\b" # not a TOML comment
+"""
+
+[rotate]
+size = '100M' # a comment
+keep = 0x10
+
+[aliases]
+"192.168.1.49" = ["kitchen-relay", "shelly"]
+"10.0.2.0/24" = "cameras"
+esc = "tab\there \u00e9"
+`
+
+func TestParse(t *testing.T) {
+	f, err := config.Parse(example, "test.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range f.Settings {
+		got = append(got, s.FlagName()+"="+strings.Join(s.Values, "|"))
+	}
+	wanted := []string{
+		"listen=udp://:514|tls://:6514",
+		"severity=info",
+		"quiet=true",
+		"queue-size=1000",
+		"rate=2.5",
+		"filter=severity <= warning &&\n  text ~ \"fail\\b\" # not a TOML comment\n",
+		"rotate-size=100M",
+		"rotate-keep=16",
+		"aliases-192.168.1.49=kitchen-relay|shelly",
+		"aliases-10.0.2.0/24=cameras",
+		"aliases-esc=tab\there é",
+	}
+	if strings.Join(got, "\n") != strings.Join(wanted, "\n") {
+		t.Errorf("got\n%s\nwanted\n%s", strings.Join(got, "\n"), strings.Join(wanted, "\n"))
+	}
+	if aliases := f.Section("aliases"); len(aliases) != 3 || aliases[0].Line != 20 || !aliases[0].IsArray || aliases[1].IsArray {
+		t.Errorf("wrong aliases section: %v", aliases)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, ex := range []struct {
+		text   string
+		wanted string
+	}{
+		{"a = 1\nb = 2\na = 3", "x:3: a was already set on line 1"},
+		{"[s]\n[t]\n[s]", "x:3: section [s] was already defined on line 1"},
+		{"\n\nname = unquoted", "x:3: invalid value unquoted (strings must be quoted)"},
+		{"a = \"open", "x:1: string has no closing \""},
+		{"a = '''\n\nopen", "x:1: string has no closing '''"},
+		{"a = [1, 2", "x:1: expected , or ] in array, found end of file"},
+		{"a = [1 2]", "x:1: expected , or ] in array, found '2'"},
+		{"a = 1 2", "x:1: unexpected '2' after value"},
+		{"keep = 010", "x:1: invalid number 010 (leading zeros are not allowed)"},
+		{"a = -0_1.5", "x:1: invalid number -0_1.5 (leading zeros are not allowed)"},
+		{"a.b = 1", "x:1: dotted keys are not supported"},
+		{"a = {b = 1}", "x:1: inline tables are not supported"},
+		{"[[s]]", "x:1: arrays of tables are not supported"},
+		{"a = \"\\q\"", "x:1: invalid escape \\q"},
+		{"= 1", "x:1: expected a key, found '='"},
+		{"a 1", "x:1: expected = after a, found '1'"},
+		{"a = [[1]]", "x:1: nested arrays are not supported"},
+		{"a =", "x:1: expected a value, found end of file"},
+	} {
+		_, err := config.Parse(ex.text, "x")
+		if err == nil || err.Error() != ex.wanted {
+			t.Errorf("%q: got error %v, wanted %s", ex.text, err, ex.wanted)
+		}
+	}
+}
+
+// list is a repeatable flag
+type list []string
+
+func (self *list) String() string     { return strings.Join(*self, ",") }
+func (self *list) Set(s string) error { *self = append(*self, s); return nil }
+func (self *list) IsRepeatable() bool { return true }
+
+func TestSetFlag(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	listen := list{}
+	fs.Var(&listen, "listen", "")
+	quiet := fs.Bool("quiet", false, "")
+	facility := fs.String("facility", "", "")
+	size := fs.Int("rotate-size", 0, "")
+	f, err := config.Parse("listen = ['a', 'b']\nquiet = true\nfacility = ['daemon', 'local0']\n[rotate]\nsize = 'big'\n[other]\nx = 1", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(name, value string) error {
+		if value == "local0" {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+	var errs []string
+	for _, s := range f.Settings {
+		if err := s.SetFlag(fs, check); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if listen.String() != "a,b" || !*quiet || *facility != "daemon,local0" || *size != 0 {
+		t.Errorf("got listen %v, quiet %v, facility %q, size %d", listen, *quiet, *facility, *size)
+	}
+	if len(errs) != 2 || !strings.HasPrefix(errs[0], "c:5: rotate-size: ") || errs[1] != "c:7: unknown option other-x" {
+		t.Errorf("got errors %q", errs)
+	}
+}
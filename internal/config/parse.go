@@ -0,0 +1,331 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parser holds the state of Parse
+type parser struct {
+	name    string
+	text    string
+	pos     int
+	line    int
+	section string
+	seen    map[string]int // Line number of each section and key
+}
+
+// Parse parses the text of a configuration file; name is used in error messages
+func Parse(text, name string) (*File, error) {
+	p := &parser{name: name, text: strings.TrimPrefix(text, "\uFEFF"), line: 1, seen: make(map[string]int)}
+	file := &File{Name: name}
+	for {
+		p.skipBlank(true)
+		if p.pos >= len(p.text) {
+			return file, nil
+		}
+		if p.text[p.pos] == '[' {
+			if err := p.parseSection(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		s, err := p.parseSetting()
+		if err != nil {
+			return nil, err
+		}
+		file.Settings = append(file.Settings, s)
+	}
+}
+
+func (self *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", self.name, self.line, fmt.Sprintf(format, args...))
+}
+
+// skipBlank skips spaces, tabs and comments, and also newlines if newlines is true
+func (self *parser) skipBlank(newlines bool) {
+	for self.pos < len(self.text) {
+		switch c := self.text[self.pos]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			self.pos++
+		case c == '\n' && newlines:
+			self.pos++
+			self.line++
+		case c == '#':
+			for self.pos < len(self.text) && self.text[self.pos] != '\n' {
+				self.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine checks nothing but a comment follows on the current line
+func (self *parser) endOfLine() error {
+	self.skipBlank(false)
+	if self.pos < len(self.text) && self.text[self.pos] != '\n' {
+		return self.errorf("unexpected %s after value", self.describe())
+	}
+	return nil
+}
+
+// describe describes the next character for error messages
+func (self *parser) describe() string {
+	if self.pos >= len(self.text) {
+		return "end of file"
+	}
+	r, _ := utf8.DecodeRuneInString(self.text[self.pos:])
+	if r == '\n' {
+		return "end of line"
+	}
+	return strconv.QuoteRune(r)
+}
+
+// parseSection parses [name]
+func (self *parser) parseSection() error {
+	self.pos++ // [
+	if strings.HasPrefix(self.text[self.pos:], "[") {
+		return self.errorf("arrays of tables are not supported")
+	}
+	self.skipBlank(false)
+	name, err := self.parseKey()
+	if err != nil {
+		return err
+	}
+	self.skipBlank(false)
+	if self.pos >= len(self.text) || self.text[self.pos] != ']' {
+		return self.errorf("expected ] after section name, found %s", self.describe())
+	}
+	self.pos++
+	if line, ok := self.seen["["+name+"]"]; ok {
+		return self.errorf("section [%s] was already defined on line %d", name, line)
+	}
+	self.seen["["+name+"]"] = self.line
+	self.section = name
+	return self.endOfLine()
+}
+
+// parseSetting parses key = value
+func (self *parser) parseSetting() (*Setting, error) {
+	s := &Setting{File: self.name, Line: self.line, Section: self.section}
+	var err error
+	if s.Key, err = self.parseKey(); err != nil {
+		return nil, err
+	}
+	self.skipBlank(false)
+	if self.pos >= len(self.text) || self.text[self.pos] != '=' {
+		return nil, self.errorf("expected = after %s, found %s", s.Key, self.describe())
+	}
+	self.pos++
+	self.skipBlank(false)
+	id := self.section + "\x00" + s.Key
+	if line, ok := self.seen[id]; ok {
+		return nil, self.errorf("%s was already set on line %d", s.Key, line)
+	}
+	self.seen[id] = self.line
+
+	if self.pos < len(self.text) && self.text[self.pos] == '[' {
+		s.IsArray = true
+		s.Values, err = self.parseArray()
+	} else {
+		var v string
+		v, err = self.parseValue()
+		s.Values = []string{v}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, self.endOfLine()
+}
+
+// isBareKeyChar returns true for characters which can be used in unquoted keys
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// parseKey parses a bare or quoted key
+func (self *parser) parseKey() (string, error) {
+	start := self.pos
+	if start < len(self.text) && (self.text[start] == '"' || self.text[start] == '\'') {
+		if strings.HasPrefix(self.text[start:], `"""`) || strings.HasPrefix(self.text[start:], `'''`) {
+			return "", self.errorf("keys can't be multi-line strings")
+		}
+		return self.parseString()
+	}
+	for self.pos < len(self.text) && isBareKeyChar(self.text[self.pos]) {
+		self.pos++
+	}
+	if self.pos == start {
+		return "", self.errorf("expected a key, found %s", self.describe())
+	}
+	if self.pos < len(self.text) && self.text[self.pos] == '.' {
+		return "", self.errorf("dotted keys are not supported")
+	}
+	return self.text[start:self.pos], nil
+}
+
+// parseArray parses [value, ...], which may span several lines and have a trailing comma
+func (self *parser) parseArray() ([]string, error) {
+	self.pos++ // [
+	values := []string{}
+	for {
+		self.skipBlank(true)
+		if self.pos < len(self.text) && self.text[self.pos] == ']' {
+			self.pos++
+			return values, nil
+		}
+		if self.pos < len(self.text) && self.text[self.pos] == '[' {
+			return nil, self.errorf("nested arrays are not supported")
+		}
+		v, err := self.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		self.skipBlank(true)
+		switch {
+		case self.pos < len(self.text) && self.text[self.pos] == ',':
+			self.pos++
+		case self.pos < len(self.text) && self.text[self.pos] == ']':
+		default:
+			return nil, self.errorf("expected , or ] in array, found %s", self.describe())
+		}
+	}
+}
+
+// parseValue parses a string, number or boolean, returning its text
+func (self *parser) parseValue() (string, error) {
+	if self.pos >= len(self.text) {
+		return "", self.errorf("expected a value, found end of file")
+	}
+	switch c := self.text[self.pos]; {
+	case c == '"' || c == '\'':
+		return self.parseString()
+	case c == '{':
+		return "", self.errorf("inline tables are not supported")
+	}
+	start := self.pos
+	for self.pos < len(self.text) && (isBareKeyChar(self.text[self.pos]) || strings.IndexByte("+.:", self.text[self.pos]) >= 0) {
+		self.pos++
+	}
+	word := self.text[start:self.pos]
+	switch {
+	case word == "true" || word == "false":
+		return word, nil
+	case word == "":
+		return "", self.errorf("expected a value, found %s", self.describe())
+	}
+	if d := strings.TrimLeft(word, "+-"); len(d) > 1 && d[0] == '0' && strings.IndexByte("0123456789_", d[1]) >= 0 {
+		return "", self.errorf("invalid number %s (leading zeros are not allowed)", word)
+	}
+	if n, err := strconv.ParseInt(strings.ReplaceAll(word, "_", ""), 0, 64); err == nil {
+		return strconv.FormatInt(n, 10), nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(word, "_", ""), 64); err == nil {
+		return strings.ReplaceAll(word, "_", ""), nil
+	}
+	return "", self.errorf("invalid value %s (strings must be quoted)", word)
+}
+
+// parseString parses a basic, literal or multi-line string
+func (self *parser) parseString() (string, error) {
+	quote := self.text[self.pos : self.pos+1]
+	multiline := strings.HasPrefix(self.text[self.pos:], quote+quote+quote)
+	if multiline {
+		quote = quote + quote + quote
+	}
+	self.pos += len(quote)
+	startLine := self.line
+	if multiline { // A newline immediately after the opening quotes is ignored
+		if strings.HasPrefix(self.text[self.pos:], "\r\n") {
+			self.pos += 2
+			self.line++
+		} else if strings.HasPrefix(self.text[self.pos:], "\n") {
+			self.pos++
+			self.line++
+		}
+	}
+	basic := quote[0] == '"'
+	b := strings.Builder{}
+	for {
+		if self.pos >= len(self.text) || (!multiline && self.text[self.pos] == '\n') {
+			self.line = startLine // Report where the string started
+			return "", self.errorf("string has no closing %s", quote)
+		}
+		if strings.HasPrefix(self.text[self.pos:], quote) {
+			self.pos += len(quote)
+			return b.String(), nil
+		}
+		c := self.text[self.pos]
+		switch {
+		case c == '\n':
+			self.line++
+		case c == '\\' && basic:
+			s, err := self.parseEscape()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+			continue
+		}
+		b.WriteByte(c)
+		self.pos++
+	}
+}
+
+// parseEscape parses an escape sequence in a basic string
+func (self *parser) parseEscape() (string, error) {
+	if self.pos+1 >= len(self.text) {
+		return "", self.errorf("string has no closing quote")
+	}
+	c := self.text[self.pos+1]
+	self.pos += 2
+	switch c {
+	case 'b':
+		return "\b", nil
+	case 't':
+		return "\t", nil
+	case 'n':
+		return "\n", nil
+	case 'f':
+		return "\f", nil
+	case 'r':
+		return "\r", nil
+	case '"':
+		return "\"", nil
+	case '\\':
+		return "\\", nil
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if self.pos+n > len(self.text) {
+			return "", self.errorf("invalid escape \\%c", c)
+		}
+		code, err := strconv.ParseUint(self.text[self.pos:self.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return "", self.errorf("invalid escape \\%c%s", c, self.text[self.pos:self.pos+n])
+		}
+		self.pos += n
+		return string(rune(code)), nil
+	}
+	return "", self.errorf("invalid escape \\%c", c)
+}
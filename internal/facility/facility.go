@@ -16,6 +16,8 @@ package facility
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // Facility - as defined in RFC 5424
@@ -38,7 +40,7 @@ var names []string = []string{
 	"authpriv",
 	"ftp",
 	"ntp",
-	"security",
+	"audit",
 	"console",
 	"solaris-cron",
 	"local0",
@@ -67,3 +69,51 @@ func (s Facility) String() string {
 func Default() Facility {
 	return Facility(1) // "user"
 }
+
+// aliases are other names used for facilities, e.g. in syslog.conf
+//
+// "security" is the deprecated syslog.conf name for auth, so facility 13 (which
+// some systems also call security) is named "audit"
+var aliases = map[string]Facility{
+	"kern":     0,
+	"security": 4,
+	"printer":  6,
+	"logaudit": 13,
+	"alert":    14,
+	"logalert": 14,
+	"clock":    15,
+	"cron2":    15,
+}
+
+// Parse converts a name, an alias such as "kern", or a number into a facility
+//
+// Values which are not recognized are given the Default() facility with a non-nil err
+func Parse(s string) (Facility, error) {
+	for i, v := range names {
+		if strings.EqualFold(s, v) {
+			return Facility(i), nil
+		}
+	}
+	if f, ok := aliases[strings.ToLower(s)]; ok {
+		return f, nil
+	}
+	i, err := strconv.ParseUint(s, 10, 8)
+	if err == nil && int(i) < len(names) {
+		return Facility(i), nil
+	}
+	return Default(), fmt.Errorf("Unknown facility value \"%s\"", s)
+}
+
+// Get string of possible Facility values in number: name format
+func PossibleValues() string {
+	builder := strings.Builder{}
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(strconv.Itoa(i))
+		builder.WriteString(":")
+		builder.WriteString(name)
+	}
+	return builder.String()
+}
@@ -15,9 +15,10 @@
 package facility_test
 
 import (
+	"strings"
 	"testing"
 
-	"github.com/m-z-b/syslogqd/facility"
+	"github.com/m-z-b/syslogqd/internal/facility"
 )
 
 type _facilityTestExample struct {
@@ -29,6 +30,7 @@ type _facilityTestExample struct {
 var _facilityExamples = []_facilityTestExample{
 	{0, "kernel"},
 	{1, "user"},
+	{13, "audit"},
 	{23, "local7"},
 	{24, "facility(24)!"},
 }
@@ -41,3 +43,61 @@ func TestFacilityExamples(t *testing.T) {
 		}
 	}
 }
+
+// These should return no error AND the supplied value
+var happyParseExamples = []_facilityTestExample{
+	{0, "0"},
+	{0, "Kernel"},
+	{0, "kern"},
+	{4, "auth"},
+	{4, "security"},
+	{13, "audit"},
+	{13, "logaudit"},
+	{9, "cron"},
+	{23, "23"},
+	{23, "LOCAL7"},
+}
+
+// These should return an error AND the default value
+var sadParseExamples = []string{"24", "", "-1", "4x", "local8"}
+
+func TestHappyParseExamples(t *testing.T) {
+	for _, ex := range happyParseExamples {
+		got, err := facility.Parse(ex.name)
+		if err != nil {
+			t.Error(err)
+		}
+		if wanted := facility.Facility(ex.number); got != wanted {
+			t.Errorf("%q: got %q, wanted %q", ex.name, got, wanted)
+		}
+	}
+}
+
+func TestSadParseExamples(t *testing.T) {
+	for _, s := range sadParseExamples {
+		got, err := facility.Parse(s)
+		if got != facility.Default() {
+			t.Errorf("expected value of %q to be %s", s, facility.Default())
+		}
+		if err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+// Parse should accept every name String returns
+func TestParseRoundTrip(t *testing.T) {
+	for i := 0; i <= 23; i++ {
+		f := facility.Facility(i)
+		if got, err := facility.Parse(f.String()); err != nil || got != f {
+			t.Errorf("%s: got %s, %v", f, got, err)
+		}
+	}
+}
+
+func TestPossibleValues(t *testing.T) {
+	got := facility.PossibleValues()
+	if !strings.HasPrefix(got, "0:kernel, 1:user, ") || !strings.HasSuffix(got, ", 23:local7") {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,443 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filter compiles boolean expressions which select syslog entries, such as
+//
+//	severity<=warning && (host=="10.0.0.5" || text~"panic") && !facility in (cron,lpr)
+//
+// A comparison is a field, an operator and a value. Values may be quoted with double
+// or single quotes, and need not be quoted if they only contain letters, digits and
+// the characters _ . : @ / -. The operators are
+//
+//	== !=             equal, not equal
+//	< <= > >=         for severity, facility, port and version only
+//	~ !~              matches, does not match a regular expression
+//	in (v1, v2, ...)  equal to any of the values
+//
+// and comparisons are combined using !, && and || with parentheses as needed.
+//
+// Severities and facilities may be given as names or numbers and are compared by
+// number, so severity<=warning selects warnings and anything more severe. Entries
+// without a PRI have severity debug and facility user. The host field matches either
+// the hostname in the message or the address of the sender.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/m-z-b/syslogqd/internal/facility"
+	"github.com/m-z-b/syslogqd/internal/severity"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// A Filter is a compiled filter expression
+type Filter struct {
+	expr string
+	root node
+}
+
+// Compile parses a filter expression
+func Compile(expr string) (*Filter, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{expr: expr, tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, syntaxError(expr, 0, "filter is empty")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, syntaxError(expr, t.pos, "unexpected %s", t.describe())
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Matches returns true if the entry passes the filter. A nil Filter matches everything.
+func (self *Filter) Matches(e *syslog.Entry) bool {
+	return self == nil || self.root.eval(e)
+}
+
+// And returns a filter which only matches entries which pass both filters. Either
+// filter may be nil.
+func (self *Filter) And(other *Filter) *Filter {
+	if self == nil {
+		return other
+	}
+	if other == nil {
+		return self
+	}
+	return &Filter{expr: "(" + self.expr + ") && (" + other.expr + ")", root: andNode{self.root, other.root}}
+}
+
+func (self *Filter) String() string {
+	return self.expr
+}
+
+// A SyntaxError describes a mistake in a filter expression
+type SyntaxError struct {
+	Expr string
+	Pos  int // Byte offset of the mistake in Expr
+	Msg  string
+}
+
+func syntaxError(expr string, pos int, format string, args ...any) error {
+	return &SyntaxError{Expr: expr, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Error describes the mistake, followed by the line containing it with a caret
+// under the position of the mistake
+func (self *SyntaxError) Error() string {
+	start := strings.LastIndexByte(self.Expr[:self.Pos], '\n') + 1
+	end := strings.IndexByte(self.Expr[self.Pos:], '\n')
+	if end < 0 {
+		end = len(self.Expr)
+	} else {
+		end += self.Pos
+	}
+	where := fmt.Sprintf("position %d", self.Pos+1)
+	if strings.Contains(self.Expr, "\n") {
+		where = fmt.Sprintf("line %d, column %d", strings.Count(self.Expr[:self.Pos], "\n")+1, self.Pos-start+1)
+	}
+	line := strings.ReplaceAll(self.Expr[start:end], "\t", " ")
+	return fmt.Sprintf("Filter error at %s: %s\n    %s\n    %s^", where, self.Msg, line, strings.Repeat(" ", self.Pos-start))
+}
+
+// Syntax trees
+
+type node interface {
+	eval(e *syslog.Entry) bool
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ n node }
+
+func (n andNode) eval(e *syslog.Entry) bool { return n.left.eval(e) && n.right.eval(e) }
+func (n orNode) eval(e *syslog.Entry) bool  { return n.left.eval(e) || n.right.eval(e) }
+func (n notNode) eval(e *syslog.Entry) bool { return !n.n.eval(e) }
+
+// numberComparison compares a numeric field with one or more values
+type numberComparison struct {
+	field  *field
+	op     string
+	values []int
+}
+
+func (n numberComparison) eval(e *syslog.Entry) bool {
+	v := n.field.number(e)
+	switch n.op {
+	case "==":
+		return v == n.values[0]
+	case "!=":
+		return v != n.values[0]
+	case "<":
+		return v < n.values[0]
+	case "<=":
+		return v <= n.values[0]
+	case ">":
+		return v > n.values[0]
+	case ">=":
+		return v >= n.values[0]
+	}
+	for _, value := range n.values { // in
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// stringComparison compares a text field with one or more values or a regex
+type stringComparison struct {
+	field  *field
+	op     string
+	values []string
+	regex  *regexp.Regexp
+}
+
+// eval compares each of the field's strings (most fields have just one): == and in
+// are true if any of them are equal, and != is true if none of them are
+func (n stringComparison) eval(e *syslog.Entry) bool {
+	found := false
+	for _, s := range n.field.strings(e) {
+		switch n.op {
+		case "~", "!~":
+			found = found || n.regex.MatchString(s)
+		default:
+			for _, value := range n.values {
+				found = found || s == value
+			}
+		}
+	}
+	if n.op == "!=" || n.op == "!~" {
+		return !found
+	}
+	return found
+}
+
+// Fields
+
+type field struct {
+	name    string
+	strings func(e *syslog.Entry) []string // nil for numeric fields
+	number  func(e *syslog.Entry) int
+	parse   func(s string) (int, error) // Converts a value for numeric fields
+}
+
+func one(s string) []string { return []string{s} }
+
+var fields = []*field{
+	{name: "severity", number: func(e *syslog.Entry) int { return int(entrySeverity(e)) }, parse: parseSeverity},
+	{name: "facility", number: func(e *syslog.Entry) int { return int(entryFacility(e)) }, parse: parseFacility},
+	{name: "host", strings: func(e *syslog.Entry) []string { return []string{e.Hostname(), e.RemoteIP(), e.Alias(), e.RemoteName()} }},
+	{name: "hostname", strings: func(e *syslog.Entry) []string { return one(e.Hostname()) }},
+	{name: "ip", strings: func(e *syslog.Entry) []string { return one(e.RemoteIP()) }},
+	{name: "remotename", strings: func(e *syslog.Entry) []string { return one(e.RemoteName()) }},
+	{name: "port", number: func(e *syslog.Entry) int { return e.RemotePort() }, parse: strconv.Atoi},
+	{name: "transport", strings: func(e *syslog.Entry) []string { return one(e.Transport()) }},
+	{name: "identity", strings: func(e *syslog.Entry) []string { return one(e.Identity()) }},
+	{name: "alias", strings: func(e *syslog.Entry) []string { return one(e.Alias()) }},
+	{name: "tag", strings: func(e *syslog.Entry) []string { return e.Tags() }},
+	{name: "version", number: func(e *syslog.Entry) int { return e.Version() }, parse: strconv.Atoi},
+	{name: "app", strings: func(e *syslog.Entry) []string { return one(e.AppName()) }},
+	{name: "procid", strings: func(e *syslog.Entry) []string { return one(e.ProcID()) }},
+	{name: "msgid", strings: func(e *syslog.Entry) []string { return one(e.MsgID()) }},
+	{name: "sd", strings: func(e *syslog.Entry) []string { return e.StructuredData().Strings() }},
+	{name: "text", strings: func(e *syslog.Entry) []string { return one(e.Text()) }},
+	{name: "message", strings: func(e *syslog.Entry) []string { return one(e.Message()) }},
+}
+
+// aliases are alternative names for fields
+var aliases = map[string]string{"sev": "severity", "fac": "facility", "appname": "app", "msg": "message"}
+
+// FieldNames returns the names of the fields which can be used in filters
+func FieldNames() string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return strings.Join(names, ", ")
+}
+
+func findField(name string) *field {
+	name = strings.ToLower(name)
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	for _, f := range fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func entrySeverity(e *syslog.Entry) severity.Severity {
+	if e.HasSeverity() {
+		return e.Severity()
+	}
+	return severity.Default()
+}
+
+func entryFacility(e *syslog.Entry) facility.Facility {
+	if e.HasSeverity() {
+		return e.Facility()
+	}
+	return facility.Default()
+}
+
+// parseSeverity accepts the abbreviations used in syslog.conf, such as err, as well as
+// the names and numbers accepted by severity.Parse
+func parseSeverity(s string) (int, error) {
+	for i := 0; i <= int(severity.Default()); i++ {
+		if strings.EqualFold(s, severity.Severity(i).Abbreviation()) {
+			return i, nil
+		}
+	}
+	v, err := severity.Parse(s)
+	return int(v), err
+}
+
+func parseFacility(s string) (int, error) {
+	v, err := facility.Parse(s)
+	return int(v), err
+}
+
+// Parser
+
+type parser struct {
+	expr   string
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// isOp returns true if the next token is the operator op
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+// parseOr parses and-expressions separated by ||
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.isOp("||") {
+		p.next()
+		var right node
+		if right, err = p.parseAnd(); err == nil {
+			left = orNode{left, right}
+		}
+	}
+	return left, err
+}
+
+// parseAnd parses unary expressions separated by &&
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.isOp("&&") {
+		p.next()
+		var right node
+		if right, err = p.parseUnary(); err == nil {
+			left = andNode{left, right}
+		}
+	}
+	return left, err
+}
+
+// parseUnary parses ! expression, ( expression ) or a comparison
+func (p *parser) parseUnary() (node, error) {
+	switch {
+	case p.isOp("!"):
+		p.next()
+		n, err := p.parseUnary()
+		return notNode{n}, err
+	case p.isOp("("):
+		open := p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, syntaxError(p.expr, p.peek().pos, "expected ')' to match '(' at position %d but found %s", open.pos+1, p.peek().describe())
+		}
+		p.next()
+		return n, nil
+	}
+	return p.parseComparison()
+}
+
+// comparisons are the operators which can follow a field name, other than in
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "~": true, "!~": true}
+
+// parseComparison parses field op value, field ~ regex or field in (value, ...)
+func (p *parser) parseComparison() (node, error) {
+	t := p.next()
+	if t.kind != tokWord {
+		return nil, syntaxError(p.expr, t.pos, "expected a field name but found %s", t.describe())
+	}
+	f := findField(t.text)
+	if f == nil {
+		return nil, syntaxError(p.expr, t.pos, "unknown field '%s' (should be one of %s)", t.text, FieldNames())
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == tokWord && strings.EqualFold(op.text, "in"):
+		op.text = "in"
+	case op.kind == tokOp && comparisons[op.text]:
+	default:
+		return nil, syntaxError(p.expr, op.pos, "expected a comparison such as ==, ~ or in after '%s' but found %s", t.text, op.describe())
+	}
+	if f.strings == nil && (op.text == "~" || op.text == "!~") {
+		return nil, syntaxError(p.expr, op.pos, "'%s' can't be used with %s", op.text, f.name)
+	}
+	if f.strings != nil && strings.ContainsAny(op.text, "<>") {
+		return nil, syntaxError(p.expr, op.pos, "'%s' can't be used with %s", op.text, f.name)
+	}
+
+	values, err := p.parseValues(op.text == "in")
+	if err != nil {
+		return nil, err
+	}
+	if f.strings != nil {
+		n := stringComparison{field: f, op: op.text}
+		for _, v := range values {
+			n.values = append(n.values, v.text)
+		}
+		if op.text == "~" || op.text == "!~" {
+			if n.regex, err = regexp.Compile(values[0].text); err != nil {
+				return nil, syntaxError(p.expr, values[0].pos, "invalid regular expression: %s", err.Error())
+			}
+		}
+		return n, nil
+	}
+	n := numberComparison{field: f, op: op.text}
+	for _, v := range values {
+		i, err := f.parse(v.text)
+		if err != nil {
+			return nil, syntaxError(p.expr, v.pos, "%s", err.Error())
+		}
+		n.values = append(n.values, i)
+	}
+	return n, nil
+}
+
+// parseValues parses a value, or if list is true a list of values in parentheses
+func (p *parser) parseValues(list bool) ([]token, error) {
+	if !list {
+		v := p.next()
+		if v.kind != tokWord && v.kind != tokString {
+			return nil, syntaxError(p.expr, v.pos, "expected a value but found %s", v.describe())
+		}
+		return []token{v}, nil
+	}
+	if !p.isOp("(") {
+		return nil, syntaxError(p.expr, p.peek().pos, "expected '(' after in but found %s", p.peek().describe())
+	}
+	p.next()
+	var values []token
+	for {
+		v, err := p.parseValues(false)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v...)
+		if p.isOp(")") {
+			p.next()
+			return values, nil
+		}
+		if !p.isOp(",") {
+			return nil, syntaxError(p.expr, p.peek().pos, "expected ',' or ')' but found %s", p.peek().describe())
+		}
+		p.next()
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/m-z-b/syslogqd/internal/filter"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func entry(ip, raw string) *syslog.Entry {
+	addr, _ := net.ResolveUDPAddr("udp", net.JoinHostPort(ip, "514"))
+	return syslog.NewEntry([]byte(raw), addr)
+}
+
+func TestFilterMatches(t *testing.T) {
+	warning := entry("10.0.0.5", "<28>1 2022-06-06T13:44:58Z sw1 kernel - - [origin@0 ip=\"1.2.3.4\"] link down") // daemon.warning
+	crit := entry("10.0.0.6", "<10>1 2022-06-06T13:44:58Z - kernel - - - panic: oops")                            // user.critical
+	cron := entry("10.0.0.5", "<75>Oct 11 22:14:15 sw1 crond[99]: job done")                                      // cron.error
+	noPRI := entry("10.0.0.7", "no priority here")
+	warning.SetAlias("core-switch", []string{"network", "core"})
+
+	for _, ex := range []struct {
+		expr    string
+		matches []*syslog.Entry
+	}{
+		{`severity<=warning`, []*syslog.Entry{warning, crit, cron}},
+		{`sev <= err`, []*syslog.Entry{crit, cron}},
+		{`severity == debug`, []*syslog.Entry{noPRI}},
+		{`severity<=warning && (host=="10.0.0.5" || text~"panic") && !facility in (cron,lpr)`, []*syslog.Entry{warning, crit}},
+		{`host == sw1`, []*syslog.Entry{warning, cron}},
+		{`host != 10.0.0.5`, []*syslog.Entry{crit, noPRI}},
+		{`ip in ("10.0.0.6", 10.0.0.7)`, []*syslog.Entry{crit, noPRI}},
+		{`app == crond && procid == 99`, []*syslog.Entry{cron}},
+		{`sd ~ 'ip=1\.2\.'`, []*syslog.Entry{warning}},
+		{`message !~ "^(sw1|-)" # comment`, []*syslog.Entry{crit, noPRI}},
+		{`facility >= 9 || !(severity > 5)`, []*syslog.Entry{warning, crit, cron}},
+		{`version == 1 && transport == udp && port == 514`, []*syslog.Entry{warning, crit}},
+		{`alias == core-switch || host == "core-switch"`, []*syslog.Entry{warning}},
+		{`tag == core`, []*syslog.Entry{warning}},
+		{`tag != network`, []*syslog.Entry{crit, cron, noPRI}},
+	} {
+		f, err := filter.Compile(ex.expr)
+		if err != nil {
+			t.Errorf("%s: %s", ex.expr, err)
+			continue
+		}
+		for _, e := range []*syslog.Entry{warning, crit, cron, noPRI} {
+			wanted := false
+			for _, m := range ex.matches {
+				wanted = wanted || m == e
+			}
+			if f.Matches(e) != wanted {
+				t.Errorf("%s: match %s: got %v, wanted %v", ex.expr, e, !wanted, wanted)
+			}
+		}
+	}
+
+	var none *filter.Filter
+	if !none.Matches(noPRI) {
+		t.Error("nil filter should match everything")
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, ex := range []struct {
+		expr string
+		pos  int
+		msg  string
+	}{
+		{``, 0, "empty"},
+		{`severity <= warning &&`, 22, "expected a field name"},
+		{`severity = warning`, 9, "'=='"},
+		{`severity <= bogus`, 12, "Unknown severity"},
+		{`colour == red`, 0, "unknown field"},
+		{`text ~ "(["`, 7, "invalid regular expression"},
+		{`(host == a`, 10, "expected ')'"},
+		{`host < a`, 5, "can't be used"},
+		{`facility in (cron lpr)`, 18, "expected ',' or ')'"},
+		{`text == "open`, 8, "no closing quote"},
+		{"host == a\n&& app app", 17, "expected a comparison"},
+		{`host == a b`, 10, "unexpected 'b'"},
+	} {
+		_, err := filter.Compile(ex.expr)
+		se, ok := err.(*filter.SyntaxError)
+		if !ok {
+			t.Errorf("%s: expected a syntax error, got %v", ex.expr, err)
+			continue
+		}
+		if se.Pos != ex.pos || !strings.Contains(se.Msg, ex.msg) {
+			t.Errorf("%s: got %q at %d, wanted %q at %d", ex.expr, se.Msg, se.Pos, ex.msg, ex.pos)
+		}
+	}
+
+	_, err := filter.Compile("host == a\n&& app app")
+	if wanted := "line 2, column 8"; !strings.Contains(err.Error(), wanted) || !strings.HasSuffix(err.Error(), "\n    && app app\n           ^") {
+		t.Errorf("error message %q should contain %q and point to the mistake", err, wanted)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"strings"
+)
+
+type tokenKind uint8
+
+const (
+	tokEOF    tokenKind = iota
+	tokWord             // A field name, keyword or unquoted value such as warning or 10.0.0.5
+	tokString           // A quoted string, with escapes removed
+	tokOp               // An operator or punctuation: && || ! ( ) , == != < <= > >= ~ !~
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // Offset in the expression
+}
+
+// describe returns a description of a token for error messages
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of filter"
+	case tokString:
+		return "\"" + t.text + "\""
+	}
+	return "'" + t.text + "'"
+}
+
+// operators are in order so that the longest match is found first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "!~", "!", "(", ")", ",", "<", ">", "~"}
+
+// isWordChar returns true for characters which can be used in unquoted words
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("_.:@/-", c) >= 0
+}
+
+// lex splits an expression into tokens. Everything from # to the end of a line is a comment.
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	i := 0
+next:
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#':
+			for i < len(expr) && expr[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			s, n, ok := lexString(expr[i:])
+			if !ok {
+				return nil, syntaxError(expr, i, "string has no closing quote")
+			}
+			tokens = append(tokens, token{tokString, s, i})
+			i += n
+		case isWordChar(c):
+			start := i
+			for i < len(expr) && isWordChar(expr[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, expr[start:i], start})
+		default:
+			for _, op := range operators {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, token{tokOp, op, i})
+					i += len(op)
+					continue next
+				}
+			}
+			if c == '&' || c == '|' || c == '=' {
+				return nil, syntaxError(expr, i, "unknown operator '%c' (did you mean '%c%c'?)", c, c, c)
+			}
+			return nil, syntaxError(expr, i, "unexpected character '%c'", c)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(expr)}), nil
+}
+
+// lexString reads a string in double or single quotes at the start of s, returning
+// its value and length. \" (or \') and \\ are the only escapes: other backslashes are
+// kept so that regular expressions such as "\d+" can be written naturally.
+func lexString(s string) (string, int, bool) {
+	quote := s[0]
+	b := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == quote:
+			return b.String(), i + 1, true
+		case c == '\\' && i+1 < len(s) && (s[i+1] == quote || s[i+1] == '\\'):
+			i++
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,172 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// MatchTarget is the form of an entry which patterns are matched against
+type MatchTarget uint8
+
+const (
+	MatchMessage MatchTarget = iota // Header fields, structured data and text, as for -regex
+	MatchLine                       // The entry in the default text format, including time and sender
+	MatchRaw                        // The message exactly as received
+)
+
+var targetNames = []string{"message", "line", "raw"}
+
+func (t MatchTarget) String() string {
+	if int(t) < len(targetNames) {
+		return targetNames[t]
+	}
+	return fmt.Sprintf("target(%d)!", int(t))
+}
+
+// ParseMatchTarget converts a string such as "raw" into a MatchTarget
+func ParseMatchTarget(s string) (MatchTarget, error) {
+	for i, name := range targetNames {
+		if strings.EqualFold(s, name) {
+			return MatchTarget(i), nil
+		}
+	}
+	return MatchMessage, fmt.Errorf("Unknown match target \"%s\" (should be one of %s)", s, strings.Join(targetNames, ", "))
+}
+
+// A Pattern is an include or exclude regular expression which counts its hits
+type Pattern struct {
+	source  string
+	regex   *regexp.Regexp
+	exclude bool
+	hits    atomic.Uint64
+}
+
+// String returns the pattern as it was given
+func (self *Pattern) String() string {
+	return self.source
+}
+
+// Exclude returns true for exclude patterns
+func (self *Pattern) Exclude() bool {
+	return self.exclude
+}
+
+// Hits is the number of entries the pattern included or excluded
+func (self *Pattern) Hits() uint64 {
+	return self.hits.Load()
+}
+
+// Patterns decide whether entries are reported using lists of regular expressions
+//
+// An entry which matches any exclude pattern is not reported. Otherwise, if there are
+// include patterns, it must match at least one of them. The first pattern to decide
+// the outcome has its hit count increased.
+type Patterns struct {
+	target     MatchTarget
+	ignoreCase bool
+	patterns   []*Pattern // In the order they were added
+}
+
+// NewPatterns returns an empty set of patterns. If ignoreCase is true, patterns
+// are matched without regard to case.
+func NewPatterns(target MatchTarget, ignoreCase bool) *Patterns {
+	return &Patterns{target: target, ignoreCase: ignoreCase}
+}
+
+// Add compiles a pattern and adds it to the include or exclude list
+func (self *Patterns) Add(expr string, exclude bool) error {
+	source := expr
+	if self.ignoreCase {
+		expr = "(?i)" + expr
+	}
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("Invalid regular expression: %s", err.Error())
+	}
+	self.patterns = append(self.patterns, &Pattern{source: source, regex: regex, exclude: exclude})
+	return nil
+}
+
+// AddFile adds the patterns in a file, one per line. Blank lines and lines starting
+// with # are ignored.
+func (self *Patterns) AddFile(name string, exclude bool) error {
+	return ReadFile(name, func(expr string) error { return self.Add(expr, exclude) })
+}
+
+// Len returns the number of patterns
+func (self *Patterns) Len() int {
+	if self == nil {
+		return 0
+	}
+	return len(self.patterns)
+}
+
+// Patterns returns the patterns in the order they were added
+func (self *Patterns) Patterns() []*Pattern {
+	if self == nil {
+		return nil
+	}
+	return self.patterns
+}
+
+// Matches returns true if the entry should be reported. Nil or empty Patterns
+// match everything.
+func (self *Patterns) Matches(e *syslog.Entry) bool {
+	if self.Len() == 0 {
+		return true
+	}
+	var targets []string
+	switch self.target {
+	case MatchLine:
+		targets = []string{e.String()}
+	case MatchRaw:
+		targets = []string{e.Raw()}
+	default:
+		targets = append([]string{e.Message()}, e.StructuredData().Strings()...)
+	}
+	matches := func(p *Pattern) bool {
+		for _, s := range targets {
+			if p.regex.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	hasIncludes := false
+	for _, p := range self.patterns {
+		if p.exclude && matches(p) {
+			p.hits.Add(1)
+			return false
+		}
+		hasIncludes = hasIncludes || !p.exclude
+	}
+	if !hasIncludes {
+		return true
+	}
+	for _, p := range self.patterns {
+		if !p.exclude && matches(p) {
+			p.hits.Add(1)
+			return true
+		}
+	}
+	return false
+}
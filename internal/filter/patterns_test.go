@@ -0,0 +1,98 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m-z-b/syslogqd/internal/filter"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func TestPatterns(t *testing.T) {
+	p := filter.NewPatterns(filter.MatchMessage, true)
+	for _, ex := range []struct {
+		expr    string
+		exclude bool
+	}{{"heartbeat", true}, {"link (up|down)", false}, {"error", false}} {
+		if err := p.Add(ex.expr, ex.exclude); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ex := range []struct {
+		raw   string
+		wants bool
+	}{
+		{"<13>HEARTBEAT ok", false},
+		{"<13>Link Down on port 1", true},
+		{"<13>heartbeat error", false}, // Excludes win
+		{"<13>Error 42", true},
+		{"<13>something else", false},
+	} {
+		if got := p.Matches(syslog.NewEntry([]byte(ex.raw), nil)); got != ex.wants {
+			t.Errorf("%s: got %v, wanted %v", ex.raw, got, ex.wants)
+		}
+	}
+	for i, wanted := range []uint64{2, 1, 1} {
+		if got := p.Patterns()[i].Hits(); got != wanted {
+			t.Errorf("%s: %d hits, wanted %d", p.Patterns()[i], got, wanted)
+		}
+	}
+
+	var none *filter.Patterns
+	if !none.Matches(syslog.NewEntry([]byte("hi"), nil)) {
+		t.Error("nil patterns should match everything")
+	}
+}
+
+func TestPatternTargets(t *testing.T) {
+	e := entry("10.0.0.5", "<13>Oct 11 22:14:15 sw1 app: hello")
+	for _, ex := range []struct {
+		target  filter.MatchTarget
+		pattern string
+		wants   bool
+	}{
+		{filter.MatchMessage, "^sw1 app: hello$", true},
+		{filter.MatchMessage, "10.0.0.5", false},
+		{filter.MatchLine, `10\.0\.0\.5 notice/user`, true},
+		{filter.MatchRaw, "^<13>Oct 11", true},
+		{filter.MatchRaw, "notice", false},
+	} {
+		p := filter.NewPatterns(ex.target, false)
+		p.Add(ex.pattern, false)
+		if got := p.Matches(e); got != ex.wants {
+			t.Errorf("%s %s: got %v, wanted %v", ex.target, ex.pattern, got, ex.wants)
+		}
+	}
+	if _, err := filter.ParseMatchTarget("bogus"); err == nil {
+		t.Error("expected an error for an unknown target")
+	}
+}
+
+func TestPatternFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "patterns")
+	os.WriteFile(name, []byte("# Noise\nheartbeat\n\n  keepalive  \n[\n"), 0644)
+	p := filter.NewPatterns(filter.MatchMessage, false)
+	err := p.AddFile(name, true)
+	if err == nil || !strings.Contains(err.Error(), "patterns:5:") {
+		t.Errorf("expected an error for line 5, got %v", err)
+	}
+	if p.Len() != 2 || p.Patterns()[1].String() != "keepalive" || !p.Patterns()[1].Exclude() {
+		t.Errorf("patterns not read correctly: %v", p.Patterns())
+	}
+}
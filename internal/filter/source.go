@@ -0,0 +1,138 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// A Source selects the entries from some devices. It is written as an IP address
+// such as 10.0.0.5, a CIDR block such as 10.0.0.0/24, host:NAME or app:NAME. A bare
+// name is a hostname, but a name which looks like an address must be written as
+// host:NAME so that a mistyped address isn't taken as a hostname. Hostnames and
+// app-names are not case sensitive and may contain * and ? wildcards.
+type Source struct {
+	text     string
+	prefix   netip.Prefix // Valid if the source is an address or CIDR block
+	hostname string       // Lower case pattern
+	appName  string       // Lower case pattern
+}
+
+// ParseSource parses a source such as "10.0.0.0/24" or "host:printer"
+func ParseSource(s string) (*Source, error) {
+	self := &Source{text: s}
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(lower, "host:"):
+		self.hostname = lower[len("host:"):]
+	case strings.HasPrefix(lower, "app:"):
+		self.appName = lower[len("app:"):]
+	case strings.Contains(s, "/"):
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR block %s", s)
+		}
+		self.prefix = prefix.Masked()
+	default:
+		if addr, err := netip.ParseAddr(s); err == nil {
+			addr = addr.WithZone("").Unmap()
+			self.prefix = netip.PrefixFrom(addr, addr.BitLen())
+		} else if looksLikeAddress(s) {
+			return nil, fmt.Errorf("invalid IP address %s (write host:%s if it is a hostname)", s, s)
+		} else {
+			self.hostname = lower
+		}
+	}
+	if self.hostname == "" && self.appName == "" && !self.prefix.IsValid() {
+		return nil, fmt.Errorf("no source given in %s", s)
+	}
+	if _, err := path.Match(self.hostname+self.appName, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %s", s)
+	}
+	return self, nil
+}
+
+// looksLikeAddress returns true if s contains only digits and dots, or a colon,
+// which hostnames never do
+func looksLikeAddress(s string) bool {
+	return strings.Contains(s, ":") || strings.Trim(s, "0123456789.") == ""
+}
+
+func (self *Source) String() string {
+	return self.text
+}
+
+// Matches returns true if the entry comes from the source
+func (self *Source) Matches(e *syslog.Entry) bool {
+	switch {
+	case self.prefix.IsValid():
+		addr, err := netip.ParseAddr(e.RemoteIP())
+		return err == nil && self.prefix.Contains(addr.WithZone("").Unmap())
+	case self.hostname != "":
+		return matchName(self.hostname, e.Hostname())
+	}
+	return matchName(self.appName, e.AppName())
+}
+
+// matchName matches a lower case pattern against a header field, which can't match
+// if it is empty
+func matchName(pattern, s string) bool {
+	matched, _ := path.Match(pattern, strings.ToLower(s))
+	return matched && s != ""
+}
+
+// cutField returns the first white space separated field of s and the rest of s
+func cutField(s string) (field, rest string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexFunc(s, unicode.IsSpace); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
+
+// ReadLines calls add with each line read from r. Blank lines and lines starting
+// with # are ignored, and errors are prefixed with name and the line number.
+func ReadLines(r io.Reader, name string, add func(string) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if err := add(s); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// ReadFile calls add with each line of a file, as ReadLines does
+func ReadFile(name string, add func(string) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ReadLines(f, name, add)
+}
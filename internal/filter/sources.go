@@ -0,0 +1,136 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/m-z-b/syslogqd/internal/severity"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// A SourceRule sets the minimum severity, and optionally a filter, for the entries
+// from one source. Rules are written as
+//
+//	SOURCE SEVERITY [FILTER]
+//
+// where SOURCE is a Source such as 10.0.0.5, 10.0.0.0/24, host:NAME or app:NAME,
+// SEVERITY is a severity name or number, or - to use the global minimum severity,
+// and FILTER is a filter expression.
+type SourceRule struct {
+	text        string
+	source      *Source
+	minSeverity severity.Severity
+	hasSeverity bool // false if the global minimum severity is used
+	filter      *Filter
+}
+
+// ParseSourceRule parses a rule such as "10.0.0.0/24 warning app!=ntpd"
+func ParseSourceRule(s string) (*SourceRule, error) {
+	self := &SourceRule{text: strings.TrimSpace(s)}
+	source, rest := cutField(self.text)
+	sev, expr := cutField(rest)
+	if source == "" || sev == "" {
+		return nil, fmt.Errorf("Source rule \"%s\" should be SOURCE SEVERITY [FILTER]", s)
+	}
+	var err error
+	if self.source, err = ParseSource(source); err != nil {
+		return nil, fmt.Errorf("Source rule \"%s\": %w", s, err)
+	}
+
+	if sev != "-" {
+		if self.minSeverity, err = severity.Parse(sev); err != nil {
+			return nil, fmt.Errorf("Source rule \"%s\": %w", s, err)
+		}
+		self.hasSeverity = true
+	}
+	if expr != "" {
+		if self.filter, err = Compile(expr); err != nil {
+			return nil, fmt.Errorf("Source rule \"%s\": %w", s, err)
+		}
+	}
+	return self, nil
+}
+
+func (self *SourceRule) String() string {
+	return self.text
+}
+
+// Applies returns true if the entry comes from the rule's source
+func (self *SourceRule) Applies(e *syslog.Entry) bool {
+	return self.source.Matches(e)
+}
+
+// Matches returns true if the entry has at least the rule's minimum severity (or the
+// global minimum if the rule doesn't have one) and passes its filter
+func (self *SourceRule) Matches(e *syslog.Entry, globalSeverity severity.Severity) bool {
+	minSeverity := globalSeverity
+	if self.hasSeverity {
+		minSeverity = self.minSeverity
+	}
+	return (!e.HasSeverity() || e.Severity().AsOrMoreSevereThan(minSeverity)) && self.filter.Matches(e)
+}
+
+// SourceRules is a list of rules in which the first rule to apply to an entry is used
+type SourceRules struct {
+	rules []*SourceRule
+}
+
+// Add parses a rule and adds it to the end of the list
+func (self *SourceRules) Add(s string) error {
+	rule, err := ParseSourceRule(s)
+	if err != nil {
+		return err
+	}
+	self.rules = append(self.rules, rule)
+	return nil
+}
+
+// AddFile adds the rules in a file, one per line. Blank lines and lines starting
+// with # are ignored.
+func (self *SourceRules) AddFile(name string) error {
+	return ReadFile(name, self.Add)
+}
+
+// Len returns the number of rules
+func (self *SourceRules) Len() int {
+	if self == nil {
+		return 0
+	}
+	return len(self.rules)
+}
+
+// Find returns the first rule which applies to the entry, or nil if there isn't one
+func (self *SourceRules) Find(e *syslog.Entry) *SourceRule {
+	if self == nil {
+		return nil
+	}
+	for _, r := range self.rules {
+		if r.Applies(e) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Matches applies the rule for the entry's source, or if there isn't one checks the
+// entry has at least the global minimum severity
+func (self *SourceRules) Matches(e *syslog.Entry, globalSeverity severity.Severity) bool {
+	if r := self.Find(e); r != nil {
+		return r.Matches(e, globalSeverity)
+	}
+	return !e.HasSeverity() || e.Severity().AsOrMoreSevereThan(globalSeverity)
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/m-z-b/syslogqd/internal/filter"
+	"github.com/m-z-b/syslogqd/internal/severity"
+)
+
+func TestSourceRules(t *testing.T) {
+	rules := &filter.SourceRules{}
+	for _, r := range []string{
+		"10.0.0.5 debug",
+		"10.0.1.0/24 error text!~heartbeat",
+		"host:printer - app==lpd",
+		"app:ntpd critical",
+		"::1 info",
+		"host:sensor-*\tinfo\ttext~temp",
+		"host:1234 info",
+	} {
+		if err := rules.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	global := severity.Severity(4) // warning
+	for _, ex := range []struct {
+		ip, raw string
+		wants   bool
+	}{
+		{"10.0.0.5", "<15>debug from the device under test", true},
+		{"10.0.0.6", "<15>debug from another device", false},
+		{"10.0.0.6", "<12>warning from another device", true},
+		{"10.0.1.9", "<12>warning from the noisy subnet", false},
+		{"10.0.1.9", "<11>heartbeat error", false},
+		{"10.0.1.9", "<11>real error", true},
+		{"10.0.2.1", "<12>Oct 11 22:14:15 printer lpd: warning", true},
+		{"10.0.2.1", "<12>Oct 11 22:14:15 printer cupsd: warning", false},
+		{"10.0.2.1", "<12>Oct 11 22:14:15 PRINTER lpd: warning", true},
+		{"10.0.2.2", "<11>Oct 11 22:14:15 clock ntpd: error", false},
+		{"::1", "<14>info over IPv6", true},
+		{"10.0.2.2", "no PRI", true},
+		{"10.0.2.3", "<14>Oct 11 22:14:15 Sensor-3 probe: temp 21C", true},
+		{"10.0.2.3", "<14>Oct 11 22:14:15 sensor-3 probe: humidity 40%", false},
+		{"10.0.2.4", "<14>Oct 11 22:14:15 1234 probe: info", true},
+	} {
+		if got := rules.Matches(entry(ex.ip, ex.raw), global); got != ex.wants {
+			t.Errorf("%s %s: got %v, wanted %v", ex.ip, ex.raw, got, ex.wants)
+		}
+	}
+
+	var none *filter.SourceRules
+	if none.Matches(entry("10.0.0.5", "<15>debug"), global) {
+		t.Error("without rules, the global severity should be used")
+	}
+}
+
+func TestBadSourceRules(t *testing.T) {
+	for _, r := range []string{"10.0.0.5", "10.0.0.0/33 debug", "10.0.0.5 loud", "10.0.0.5 debug text~", "host: debug",
+		"10.0.0.300 debug", "10.0.0.5:514 debug", "fe80::1%eth0/64 debug", "host:[a debug"} {
+		if _, err := filter.ParseSourceRule(r); err == nil {
+			t.Errorf("%s: expected an error", r)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package forwarder relays syslog entries to another syslog server
+package forwarder
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// Format is the message format used when forwarding
+type Format uint8
+
+const (
+	RFC5424 Format = iota
+	RFC3164
+)
+
+var formatNames = []string{"rfc5424", "rfc3164"}
+
+func (f Format) String() string {
+	if int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+	return fmt.Sprintf("format(%d)!", int(f))
+}
+
+// ParseFormat converts a string such as "rfc3164" into a Format
+func ParseFormat(s string) (Format, error) {
+	for i, name := range formatNames {
+		if strings.EqualFold(s, name) {
+			return Format(i), nil
+		}
+	}
+	return RFC5424, fmt.Errorf("Unknown forwarding format \"%s\" (should be one of %s)", s, strings.Join(formatNames, ", "))
+}
+
+const (
+	DialTimeout     = 10 * time.Second
+	WriteTimeout    = 10 * time.Second
+	MinBackoff      = time.Second // Wait before the first reconnection attempt
+	MaxBackoff      = time.Minute // The wait doubles after each failure up to this
+	DefaultMaxSpool = 100 << 20   // Bytes
+	pendingSize     = 1000        // Messages waiting to be sent
+	replayChunk     = 100         // Spooled messages sent between checks for new entries
+)
+
+// Options controls how entries are forwarded
+type Options struct {
+	Format   Format
+	TLS      *tls.Config // If not nil, TCP connections use TLS (RFC 5425)
+	Spool    string      // File which holds messages while the server is unreachable ("" = drop them)
+	MaxSpool int64       // Maximum size of the spool file in bytes (0 = DefaultMaxSpool)
+	OnError  func(error)
+}
+
+// A Forwarder sends entries to a syslog server over UDP, TCP or TLS
+//
+// Entries keep their original PRI and timestamp. The hostname is the one in the
+// message, or if there isn't one the address of the device which sent it. Over
+// TCP, RFC 5424 messages and all TLS messages use octet counting; RFC 3164 messages
+// are terminated by a newline.
+//
+// While the server can't be reached, messages are appended to the spool file and
+// the connection is retried with exponential backoff. Once it is reconnected, the
+// spooled messages are sent first so the order is kept. If entries arrive faster
+// than they can be sent or spooled, they are dropped rather than delaying the caller.
+type Forwarder struct {
+	network    string // udp or tcp
+	address    string
+	options    Options
+	pending    chan string
+	closing    chan struct{}
+	done       chan struct{}
+	conn       net.Conn      // nil if not connected
+	broken     chan struct{} // Closed when the server closes conn
+	backoff    time.Duration
+	nextDial   time.Time
+	nextReplay time.Time // When to try again if the spool file couldn't be opened
+	spoolFile  *os.File  // Open for appending while messages are being spooled
+	spoolSize  int64
+	replaying  *os.File      // The spool file while it is being sent, or nil
+	replayed   *bufio.Reader // Reads the messages in replaying which haven't been sent
+	forwarded  atomic.Uint64
+	spooled    atomic.Uint64
+	dropped    atomic.Uint64
+}
+
+// New returns a Forwarder which sends entries to address and starts it
+//
+// The network is udp, udp4, udp6, tcp, tcp4 or tcp6
+func New(network, address string, options Options) (*Forwarder, error) {
+	if options.MaxSpool <= 0 {
+		options.MaxSpool = DefaultMaxSpool
+	}
+	if options.OnError == nil {
+		options.OnError = func(error) {}
+	}
+	if options.TLS != nil && !strings.HasPrefix(network, "tcp") {
+		return nil, fmt.Errorf("Can only forward over TLS using TCP")
+	}
+	self := &Forwarder{
+		network:  network,
+		address:  address,
+		options:  options,
+		pending:  make(chan string, pendingSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+		backoff:  MinBackoff,
+		nextDial: time.Now(),
+	}
+	if options.Spool != "" {
+		// Check the spool can be written, and send anything left in it last time
+		f, err := os.OpenFile(options.Spool, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		self.spoolFile, self.spoolSize = f, fi.Size()
+	}
+	go self.run()
+	return self, nil
+}
+
+// Forwarded is the number of entries sent to the server
+func (self *Forwarder) Forwarded() uint64 {
+	return self.forwarded.Load()
+}
+
+// Spooled is the number of entries which were written to the spool file
+func (self *Forwarder) Spooled() uint64 {
+	return self.spooled.Load()
+}
+
+// Dropped is the number of entries which could not be sent or spooled
+func (self *Forwarder) Dropped() uint64 {
+	return self.dropped.Load()
+}
+
+// WriteEntry queues the entry to be forwarded (the formatted line is not used)
+func (self *Forwarder) WriteEntry(e *syslog.Entry, line string) {
+	m := e.RFC5424()
+	if self.options.Format == RFC3164 {
+		m = e.RFC3164()
+	}
+	select {
+	case <-self.closing:
+		self.dropped.Add(1)
+		return
+	default:
+	}
+	select {
+	case self.pending <- m:
+	default: // Don't hold up the other outputs
+		self.dropped.Add(1)
+	}
+}
+
+// Close sends or spools any queued entries and closes the connection
+func (self *Forwarder) Close() error {
+	close(self.closing)
+	<-self.done
+	return nil
+}
+
+// run sends queued messages until the Forwarder is closed
+func (self *Forwarder) run() {
+	defer close(self.done)
+	ready := make(chan struct{})
+	close(ready)
+	for {
+		if self.conn == nil && !time.Now().Before(self.nextDial) {
+			self.connect()
+		}
+		var retry <-chan time.Time
+		var replay <-chan struct{} // Ready while there are spooled messages to send
+		if self.conn == nil {
+			retry = time.After(time.Until(self.nextDial))
+		} else if self.spoolSize > 0 {
+			if wait := time.Until(self.nextReplay); wait > 0 {
+				retry = time.After(wait)
+			} else {
+				replay = ready
+			}
+		}
+		select {
+		case m := <-self.pending:
+			self.forward(m)
+		case <-replay:
+			self.replay()
+		case <-retry:
+		case <-self.broken:
+			self.disconnect(fmt.Errorf("Connection to %s closed by server", self.address))
+		case <-self.closing:
+			for {
+				select {
+				case m := <-self.pending:
+					self.forward(m)
+				default:
+					self.disconnect(nil)
+					if self.replaying != nil {
+						self.keepUnsent("")
+					}
+					if self.spoolFile != nil {
+						self.spoolFile.Close()
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// connect tries to connect to the server, scheduling the next attempt if it fails
+func (self *Forwarder) connect() {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: DialTimeout}
+	if self.options.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, self.network, self.address, self.options.TLS)
+	} else {
+		conn, err = dialer.Dial(self.network, self.address)
+	}
+	if err != nil {
+		self.options.OnError(fmt.Errorf("Could not connect to %s (retrying in %s): %w", self.address, self.backoff, err))
+		self.nextDial = time.Now().Add(self.backoff)
+		self.backoff = min(2*self.backoff, MaxBackoff)
+		return
+	}
+	self.conn = conn // The backoff is only reset once a message has been sent
+
+	// Servers don't send anything, so a read only returns when the connection is
+	// closed (or for UDP, when the server's port is unreachable)
+	broken := make(chan struct{})
+	self.broken = broken
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(broken)
+	}()
+}
+
+// disconnect closes the connection, reporting err if it is not nil, and schedules the
+// next connection attempt so that a server which accepts connections and then drops
+// them isn't retried continuously
+func (self *Forwarder) disconnect(err error) {
+	if err != nil {
+		self.options.OnError(err)
+	}
+	if self.conn != nil {
+		self.conn.Close()
+	}
+	self.conn, self.broken = nil, nil
+	self.nextDial = time.Now().Add(self.backoff)
+	self.backoff = min(2*self.backoff, MaxBackoff)
+}
+
+// forward sends a message, spooling it if that isn't possible
+func (self *Forwarder) forward(m string) {
+	if self.conn != nil && self.spoolSize == 0 {
+		err := self.send(m)
+		if err == nil {
+			self.forwarded.Add(1)
+			self.backoff = MinBackoff
+			return
+		}
+		self.disconnect(fmt.Errorf("Could not forward to %s: %w", self.address, err))
+	}
+	self.spool(m)
+}
+
+// send writes a message to the connection
+func (self *Forwarder) send(m string) error {
+	frame := m
+	switch {
+	case strings.HasPrefix(self.network, "udp"):
+	case self.options.Format == RFC3164 && self.options.TLS == nil:
+		frame = m + "\n"
+	default:
+		frame = strconv.Itoa(len(m)) + " " + m
+	}
+	self.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	_, err := io.WriteString(self.conn, frame)
+	return err
+}
+
+// spool appends a message to the spool file as "length message"
+func (self *Forwarder) spool(m string) {
+	if self.options.Spool == "" || self.spoolSize+int64(len(m)) > self.options.MaxSpool {
+		self.dropped.Add(1)
+		return
+	}
+	if self.spoolFile == nil {
+		f, err := os.OpenFile(self.options.Spool, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			self.options.OnError(err)
+			self.dropped.Add(1)
+			return
+		}
+		self.spoolFile = f
+	}
+	n, err := fmt.Fprintf(self.spoolFile, "%d %s", len(m), m)
+	self.spoolSize += int64(n)
+	if err != nil {
+		self.options.OnError(err)
+		self.dropped.Add(1)
+		return
+	}
+	self.spooled.Add(1)
+}
+
+// replay sends up to replayChunk messages from the spool file. When they have all
+// been sent the file is emptied; if one can't be sent, the file is replaced by the
+// messages which haven't been sent.
+//
+// Messages spooled while the file is being replayed are appended to it, so they are
+// sent in order.
+func (self *Forwarder) replay() {
+	if self.replaying == nil {
+		f, err := os.Open(self.options.Spool)
+		if errors.Is(err, os.ErrNotExist) {
+			// The spooled messages have been lost: spool new ones to a new file
+			self.options.OnError(fmt.Errorf("Spool file %s has been removed", self.options.Spool))
+			if self.spoolFile != nil {
+				self.spoolFile.Close()
+				self.spoolFile = nil
+			}
+			self.spoolSize = 0
+			return
+		}
+		if err != nil {
+			self.options.OnError(fmt.Errorf("Could not replay spool file (retrying in %s): %w", self.backoff, err))
+			self.nextReplay = time.Now().Add(self.backoff)
+			self.backoff = min(2*self.backoff, MaxBackoff)
+			return
+		}
+		self.replaying, self.replayed = f, bufio.NewReader(f)
+	}
+	for i := 0; i < replayChunk; i++ {
+		m, err := readSpooled(self.replayed)
+		if err != nil {
+			if err != io.EOF {
+				// Nothing more can be read, so it can't be sent either
+				self.options.OnError(fmt.Errorf("Spool file %s is corrupt: %w", self.options.Spool, err))
+			}
+			self.replaying.Close()
+			self.replaying, self.replayed = nil, nil
+			if err := os.Truncate(self.options.Spool, 0); err != nil {
+				self.options.OnError(err)
+			}
+			self.spoolSize = 0
+			return
+		}
+		if err := self.send(m); err != nil {
+			self.disconnect(fmt.Errorf("Could not forward to %s: %w", self.address, err))
+			self.keepUnsent(m)
+			return
+		}
+		self.forwarded.Add(1)
+		self.backoff = MinBackoff
+	}
+}
+
+// keepUnsent replaces the spool file being replayed with m (unless it is "") followed
+// by the messages which haven't been replayed
+func (self *Forwarder) keepUnsent(m string) {
+	if self.spoolFile != nil {
+		self.spoolFile.Close() // It would still refer to the old file
+		self.spoolFile = nil
+	}
+	tmp := self.options.Spool + ".tmp"
+	size, err := unsent(tmp, m, self.replayed)
+	self.replaying.Close()
+	self.replaying, self.replayed = nil, nil
+	if err == nil {
+		err = os.Rename(tmp, self.options.Spool)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		self.options.OnError(err)
+		return
+	}
+	self.spoolSize = size
+}
+
+// unsent writes m (unless it is "") followed by the rest of r to a new spool file,
+// returning its size
+func unsent(name string, m string, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	if m != "" {
+		n, err = fmt.Fprintf(f, "%d %s", len(m), m)
+	}
+	var rest int64
+	if err == nil {
+		rest, err = io.Copy(f, r)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return int64(n) + rest, err
+}
+
+// readSpooled reads a "length message" record from the spool file
+func readSpooled(r *bufio.Reader) (string, error) {
+	count, err := r.ReadString(' ')
+	if err == io.EOF && count == "" {
+		return "", io.EOF
+	}
+	if err != nil {
+		return "", io.ErrUnexpectedEOF
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(count, " "))
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("invalid message length %q", count)
+	}
+	m := make([]byte, n)
+	if _, err := io.ReadFull(r, m); err != nil {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(m), nil
+}
@@ -0,0 +1,297 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forwarder_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/forwarder"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// receive accepts a connection and reads n octet-counted messages from it
+func receive(t *testing.T, l net.Listener, n int) []string {
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(c)
+	var messages []string
+	for i := 0; i < n; i++ {
+		count, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatalf("after %v: %s", messages, err)
+		}
+		length, _ := strconv.Atoi(strings.TrimSpace(count))
+		m := make([]byte, length)
+		if _, err := io.ReadFull(r, m); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, string(m))
+	}
+	return messages
+}
+
+func entry(i int) *syslog.Entry {
+	addr, _ := net.ResolveUDPAddr("udp", "10.1.2.3:514")
+	return syslog.NewEntry([]byte(fmt.Sprintf("<11>1 2022-06-06T13:44:58Z - app - - - message %d", i)), addr)
+}
+
+func TestForwardTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := forwarder.New("tcp", l.Addr().String(), forwarder.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 1; i <= 2; i++ {
+		f.WriteEntry(entry(i), "")
+	}
+	got := receive(t, l, 2)
+	if wanted := "<11>1 2022-06-06T13:44:58.000000Z 10.1.2.3 app - - - message 2"; got[1] != wanted {
+		t.Errorf("got %q, wanted %q", got[1], wanted)
+	}
+}
+
+func TestForwardSpool(t *testing.T) {
+	// Find a port with nothing listening on it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	f, err := forwarder.New("tcp", address, forwarder.Options{Spool: filepath.Join(t.TempDir(), "spool")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 1; i <= 3; i++ {
+		f.WriteEntry(entry(i), "")
+	}
+	for deadline := time.Now().Add(2 * time.Second); f.Spooled() < 3 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f.Spooled() != 3 {
+		t.Fatalf("%d entries spooled, wanted 3", f.Spooled())
+	}
+
+	// The spooled entries are sent in order once the server is available
+	if l, err = net.Listen("tcp", address); err != nil {
+		t.Skip("port reused:", err)
+	}
+	defer l.Close()
+	f.WriteEntry(entry(4), "")
+	got := receive(t, l, 4)
+	for i, m := range got {
+		if !strings.HasSuffix(m, fmt.Sprintf("message %d", i+1)) {
+			t.Errorf("message %d is %q", i+1, m)
+		}
+	}
+	if f.Dropped() != 0 {
+		t.Errorf("%d entries dropped", f.Dropped())
+	}
+}
+
+// If the spool file is removed, its messages are lost but later messages are still sent
+func TestForwardRemovedSpool(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	spool := filepath.Join(t.TempDir(), "spool")
+	var errors atomic.Int32
+	removed := make(chan struct{}, 1)
+	onError := func(err error) {
+		if errors.Add(1); strings.Contains(err.Error(), "removed") {
+			select {
+			case removed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	f, err := forwarder.New("tcp", address, forwarder.Options{Spool: spool, OnError: onError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 1; i <= 3; i++ {
+		f.WriteEntry(entry(i), "")
+	}
+	for deadline := time.Now().Add(2 * time.Second); f.Spooled() < 3 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := os.Remove(spool); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err = net.Listen("tcp", address); err != nil {
+		t.Skip("port reused:", err)
+	}
+	defer l.Close()
+	select {
+	case <-removed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("removal of the spool file was not reported")
+	}
+	f.WriteEntry(entry(4), "")
+	if got := receive(t, l, 1); !strings.HasSuffix(got[0], "message 4") {
+		t.Errorf("got %q", got[0])
+	}
+	if n := errors.Load(); n > 10 {
+		t.Errorf("%d errors reported", n)
+	}
+}
+
+// A server which accepts connections and then drops them is retried with backoff
+func TestForwardBackoff(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			c.(*net.TCPConn).SetLinger(0) // Reset the connection
+			c.Close()
+		}
+	}()
+
+	f, err := forwarder.New("tcp", l.Addr().String(), forwarder.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := 0; i < 50; i++ {
+		f.WriteEntry(entry(i), "")
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := accepted.Load(); n > 2 {
+		t.Errorf("%d connections in 0.5s", n)
+	}
+}
+
+// Replaying a large spool doesn't hold up WriteEntry or Close, and the messages
+// which haven't been sent are kept
+func TestForwardLargeSpool(t *testing.T) {
+	spool := filepath.Join(t.TempDir(), "spool")
+	b := strings.Builder{}
+	const spooled = 200000
+	m := entry(0).RFC5424()
+	for i := 0; i < spooled; i++ {
+		fmt.Fprintf(&b, "%d %s", len(m), m)
+	}
+	if err := os.WriteFile(spool, []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow server
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 1024)
+		for {
+			if _, err := c.Read(buf); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	f, err := forwarder.New("tcp", l.Addr().String(), forwarder.Options{Spool: spool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 5000; i++ {
+		f.WriteEntry(entry(i), "")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("WriteEntry took %s", d)
+	}
+	time.Sleep(100 * time.Millisecond)
+	start = time.Now()
+	f.Close()
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Close took %s", d)
+	}
+
+	// The spool starts with the first message which wasn't sent
+	r := bufio.NewReader(strings.NewReader(readFile(t, spool)))
+	count, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatalf("spool is empty: %s", err)
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(count))
+	first := make([]byte, n)
+	if _, err := io.ReadFull(r, first); err != nil || string(first) != m {
+		t.Errorf("spool starts with %q", first)
+	}
+	if f.Forwarded() == 0 || f.Forwarded() >= spooled {
+		t.Errorf("%d messages forwarded", f.Forwarded())
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := forwarder.ParseFormat("RFC3164"); err != nil || f != forwarder.RFC3164 {
+		t.Errorf("got %v, %v", f, err)
+	}
+	if _, err := forwarder.ParseFormat("gelf"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
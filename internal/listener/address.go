@@ -0,0 +1,96 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Default ports for each protocol
+const (
+	DefaultPort    = 514
+	DefaultTLSPort = 6514
+)
+
+// Address describes where a listener should listen, such as udp://192.168.1.10:514
+type Address struct {
+	Scheme  string // udp, udp4, udp6, tcp, tcp4, tcp6, tls, tls4, tls6, unix or unixgram
+	Address string // host:port for network listeners, or a path for unix sockets
+}
+
+// Network returns the network name to pass to net.Listen, e.g. tcp4 for tls4
+func (a Address) Network() string {
+	if strings.HasPrefix(a.Scheme, "tls") {
+		return "tcp" + a.Scheme[3:]
+	}
+	return a.Scheme
+}
+
+// IsTLS returns true if the address is for a TLS listener
+func (a Address) IsTLS() bool {
+	return strings.HasPrefix(a.Scheme, "tls")
+}
+
+func (a Address) String() string {
+	return a.Scheme + "://" + a.Address
+}
+
+// ParseAddress parses a listen address of the form scheme://host:port or scheme:///path
+//
+// The host may be an IPv4 address, an IPv6 address in brackets, a name, or empty to
+// listen on all interfaces. If the port is omitted, the default port for the
+// protocol is used.
+func ParseAddress(s string) (Address, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		return Address{}, fmt.Errorf("listen address %q must start with a protocol such as udp://", s)
+	}
+	a := Address{Scheme: strings.ToLower(scheme)}
+	switch a.Scheme {
+	case "unix", "unixgram":
+		if rest == "" {
+			return Address{}, fmt.Errorf("listen address %q has no path", s)
+		}
+		a.Address = rest
+		return a, nil
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "tls", "tls4", "tls6":
+	default:
+		return Address{}, fmt.Errorf("listen address %q has unknown protocol %q", s, scheme)
+	}
+
+	rest = strings.TrimSuffix(rest, "/")
+	host, port, err := net.SplitHostPort(rest)
+	if err != nil {
+		// No port: the host may still be a bracketed IPv6 address
+		host, port = strings.TrimSuffix(strings.TrimPrefix(rest, "["), "]"), ""
+		if strings.Contains(host, "]") || (strings.Contains(host, ":") && !strings.HasPrefix(rest, "[")) {
+			return Address{}, fmt.Errorf("listen address %q is not valid: %s", s, err.Error())
+		}
+	}
+	if port == "" {
+		port = strconv.Itoa(DefaultPort)
+		if a.IsTLS() {
+			port = strconv.Itoa(DefaultTLSPort)
+		}
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return Address{}, fmt.Errorf("listen address %q: port must be in the range 1..65535", s)
+	}
+	a.Address = net.JoinHostPort(host, port)
+	return a, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener_test
+
+import (
+	"testing"
+
+	"github.com/m-z-b/syslogqd/internal/listener"
+)
+
+var happyAddressExamples = []struct {
+	s       string
+	scheme  string
+	network string
+	address string
+}{
+	{"udp://192.168.1.10:514", "udp", "udp", "192.168.1.10:514"},
+	{"UDP://192.168.1.10", "udp", "udp", "192.168.1.10:514"},
+	{"tcp://[::1]:6514", "tcp", "tcp", "[::1]:6514"},
+	{"tcp6://[::1]", "tcp6", "tcp6", "[::1]:514"},
+	{"udp4://:5140", "udp4", "udp4", ":5140"},
+	{"tls://", "tls", "tcp", ":6514"},
+	{"tls4://0.0.0.0/", "tls4", "tcp4", "0.0.0.0:6514"},
+	{"unixgram:///run/syslogqd.sock", "unixgram", "unixgram", "/run/syslogqd.sock"},
+	{"unix://./syslogqd.sock", "unix", "unix", "./syslogqd.sock"},
+}
+
+var sadAddressExamples = []string{
+	"192.168.1.10:514",
+	"http://192.168.1.10",
+	"udp://::1",
+	"udp://192.168.1.10:0",
+	"tcp://192.168.1.10:65536",
+	"tcp://[::1]:x",
+	"unix://",
+}
+
+func TestParseAddress(t *testing.T) {
+	for _, ex := range happyAddressExamples {
+		a, err := listener.ParseAddress(ex.s)
+		if err != nil {
+			t.Errorf("%q: %s", ex.s, err)
+			continue
+		}
+		if a.Scheme != ex.scheme || a.Network() != ex.network || a.Address != ex.address {
+			t.Errorf("%q: got %s %s %s", ex.s, a.Scheme, a.Network(), a.Address)
+		}
+	}
+	for _, s := range sadAddressExamples {
+		if a, err := listener.ParseAddress(s); err == nil {
+			t.Errorf("%q: expected an error, got %s", s, a)
+		}
+	}
+}
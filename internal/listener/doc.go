@@ -14,6 +14,6 @@
 
 /*
 Listeners accept connections using various protocols, create syslog entries,
-and send them to the supplied channel.
+and send them to the supplied queue.
 */
 package listener
@@ -0,0 +1,243 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+)
+
+// framing is the method used to delimit messages in a stream (RFC 6587)
+type framing int
+
+const (
+	framingUnknown      framing = iota // Not yet detected
+	framingOctetCounted                // MSG-LEN SP SYSLOG-MSG
+	framingLF                          // SYSLOG-MSG LF
+	framingNUL                         // SYSLOG-MSG NUL
+)
+
+// maxFrameSize is the largest message we will accept from a stream: longer
+// messages are split, or for octet counted framing truncated
+const maxFrameSize = 64 * 1024
+
+// maxCountDigits is the most digits accepted in an octet count
+const maxCountDigits = 9
+
+// Regex used to identify the start of messages when no framing can be detected
+var entryStart = regexp.MustCompile(`<[0-9]{1,3}>`)
+
+// A framer splits a stream of bytes into syslog messages
+//
+// The framing is detected from the first message on the connection. If a later
+// message does not fit an octet counted framing, the framing is detected again.
+type framer struct {
+	framing  framing
+	buf      []byte
+	consumed int // Bytes at the start of buf which have been returned as messages
+	discard  int // Bytes still to be received of a truncated octet counted message
+}
+
+// newFramer returns a framer which will initially expect the given framing
+func newFramer(f framing) *framer {
+	return &framer{framing: f, buf: make([]byte, 0, 4096)}
+}
+
+// split adds data to the buffer and returns any complete messages
+//
+// The returned messages are only valid until the next call to split or flush
+func (self *framer) split(data []byte) [][]byte {
+	self.compact()
+	self.buf = append(self.buf, data...)
+	var msgs [][]byte
+	for {
+		self.skipSeparators()
+		msg := self.next()
+		if msg == nil {
+			return msgs
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// flush is called when the connection is idle (eof=false) or closed (eof=true)
+//
+// Partial messages in a known framing are kept until the connection is closed.
+// If we have not been able to detect any framing, we fall back to splitting the
+// buffer wherever something looks like the start of a syslog message.
+func (self *framer) flush(eof bool) [][]byte {
+	self.compact()
+	self.skipSeparators()
+	rest := self.buf[self.consumed:]
+	if len(rest) == 0 || (!eof && self.framing != framingUnknown) {
+		return nil
+	}
+	self.consumed = len(self.buf)
+	if self.framing == framingOctetCounted {
+		if _, header, ok := octetCount(rest); ok {
+			rest = rest[header:]
+		}
+		return [][]byte{rest}
+	}
+	var msgs [][]byte
+	for loc := entryStart.FindIndex(rest[1:]); loc != nil; loc = entryStart.FindIndex(rest[1:]) {
+		msgs = append(msgs, rest[:loc[0]+1])
+		rest = rest[loc[0]+1:]
+	}
+	return append(msgs, bytes.TrimRight(rest, "\r\n\x00"))
+}
+
+// compact discards messages which have already been returned
+func (self *framer) compact() {
+	if self.consumed > 0 {
+		self.buf = self.buf[:copy(self.buf, self.buf[self.consumed:])]
+		self.consumed = 0
+	}
+}
+
+// skipSeparators skips the rest of a truncated message, then any white space and
+// terminators between messages
+func (self *framer) skipSeparators() {
+	if self.discard > 0 {
+		n := min(self.discard, len(self.buf)-self.consumed)
+		self.consumed += n
+		self.discard -= n
+		if self.discard > 0 {
+			return
+		}
+	}
+	for self.consumed < len(self.buf) {
+		switch self.buf[self.consumed] {
+		case ' ', '\t', '\r', '\n', 0:
+			self.consumed++
+		default:
+			return
+		}
+	}
+}
+
+// next returns the next complete message in the buffer, or nil
+func (self *framer) next() []byte {
+	rest := self.buf[self.consumed:]
+	if len(rest) == 0 || self.discard > 0 {
+		return nil
+	}
+	if self.framing == framingUnknown || (self.framing == framingOctetCounted && !isDigit(rest[0])) {
+		self.framing = detectFraming(rest)
+	}
+	if self.framing == framingOctetCounted {
+		if _, _, ok := octetCount(rest); !ok && len(rest) > maxCountDigits {
+			self.framing = detectFraming(rest) // Not a valid count, so the framing has changed
+		}
+	}
+	switch self.framing {
+	case framingOctetCounted:
+		n, header, ok := octetCount(rest)
+		switch {
+		case !ok:
+			return nil // Need more of the count
+		case n > maxFrameSize && len(rest) >= header+maxFrameSize:
+			// Too long: return the start and discard the rest as it arrives
+			self.consumed += header + maxFrameSize
+			self.discard = n - maxFrameSize
+			return rest[header : header+maxFrameSize]
+		case len(rest) < header+n:
+			return nil
+		}
+		self.consumed += header + n
+		return rest[header : header+n]
+	case framingUnknown:
+		// Without framing, split a long buffer before the start of a message, as flush does
+		if len(rest) >= maxFrameSize {
+			n := maxFrameSize
+			if loc := entryStart.FindIndex(rest[1:maxFrameSize]); loc != nil {
+				n = loc[0] + 1
+			}
+			self.consumed += n
+			return rest[:n]
+		}
+	case framingLF, framingNUL:
+		terminator := byte('\n')
+		if self.framing == framingNUL {
+			terminator = 0
+		}
+		i := bytes.IndexByte(rest, terminator)
+		switch {
+		case i >= 0:
+			self.consumed += i + 1
+			return bytes.TrimRight(rest[:i], "\r")
+		case len(rest) >= maxFrameSize:
+			self.consumed += maxFrameSize
+			return rest[:maxFrameSize]
+		}
+	}
+	return nil
+}
+
+// detectFraming looks at the start of a message to decide how it is framed
+//
+// An octet count must be followed by the start of the PRI. Otherwise the framing is
+// decided by whichever of LF and NUL appears first. If the buffer does not contain
+// enough to decide, framingUnknown is returned.
+func detectFraming(b []byte) framing {
+	if isDigit(b[0]) {
+		_, header, ok := octetCount(b)
+		switch {
+		case ok && header < len(b) && b[header] == '<':
+			return framingOctetCounted
+		case ok && header == len(b):
+			return framingUnknown // Need more data
+		case !ok && len(b) <= maxCountDigits && allDigits(b):
+			return framingUnknown // Need more data
+		}
+	}
+	for _, c := range b {
+		switch c {
+		case '\n':
+			return framingLF
+		case 0:
+			return framingNUL
+		}
+	}
+	return framingUnknown
+}
+
+// octetCount parses "MSG-LEN SP" at the start of b returning the length and the
+// size of the header
+func octetCount(b []byte) (n int, header int, ok bool) {
+	i := bytes.IndexByte(b, ' ')
+	if i < 1 || i > maxCountDigits || !allDigits(b[:i]) || b[0] == '0' {
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(string(b[:i]))
+	if err != nil {
+		return 0, 0, false
+	}
+	return n, i + 1, true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func allDigits(b []byte) bool {
+	for _, c := range b {
+		if !isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
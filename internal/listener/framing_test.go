@@ -0,0 +1,139 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// frames feeds the chunks to a framer as separate reads and then closes the stream
+func frames(chunks ...string) []string {
+	f := newFramer(framingUnknown)
+	var r []string
+	for _, chunk := range chunks {
+		for _, msg := range f.split([]byte(chunk)) {
+			r = append(r, string(msg))
+		}
+	}
+	for _, msg := range f.flush(true) {
+		r = append(r, string(msg))
+	}
+	return r
+}
+
+var framingExamples = []struct {
+	name   string
+	chunks []string
+	wanted []string
+}{
+	{"octet counted",
+		[]string{"11 <7>msg <12>", "17 <34>1 - - - - - -"},
+		[]string{"<7>msg <12>", "<34>1 - - - - - -"}},
+	{"octet counted split in header",
+		[]string{"1", "1 <7>msg", " <12>8 <7>a\nb"},
+		[]string{"<7>msg <12>", "<7>a\nb"}},
+	{"LF terminated",
+		[]string{"<7>one <12> two\n<13>thr", "ee\r\n<14>four"},
+		[]string{"<7>one <12> two", "<13>three", "<14>four"}},
+	{"NUL terminated",
+		[]string{"<7>one\x00<13>two\nlines\x00"},
+		[]string{"<7>one", "<13>two\nlines"}},
+	{"LF terminated starting with digits",
+		[]string{"12 o'clock\n<7>msg\n"},
+		[]string{"12 o'clock", "<7>msg"}},
+	{"no framing",
+		[]string{"<7>one <12>two"},
+		[]string{"<7>one ", "<12>two"}},
+}
+
+func TestFraming(t *testing.T) {
+	for _, ex := range framingExamples {
+		if got := frames(ex.chunks...); !reflect.DeepEqual(got, ex.wanted) {
+			t.Errorf("%s: got %q, wanted %q", ex.name, got, ex.wanted)
+		}
+	}
+}
+
+// Partial messages are kept when the connection is idle once the framing is known
+func TestFramingIdle(t *testing.T) {
+	f := newFramer(framingUnknown)
+	if msgs := f.split([]byte("<7>one\n<7>tw")); len(msgs) != 1 {
+		t.Errorf("got %d messages, wanted 1", len(msgs))
+	}
+	if msgs := f.flush(false); len(msgs) != 0 {
+		t.Errorf("partial message %q reported while idle", msgs)
+	}
+	if msgs := f.split([]byte("o\n")); len(msgs) != 1 || string(msgs[0]) != "<7>two" {
+		t.Errorf("got %q, wanted <7>two", msgs)
+	}
+
+	// Without framing, the buffer is reported when idle
+	f = newFramer(framingUnknown)
+	f.split([]byte("<7>no terminator"))
+	if msgs := f.flush(false); len(msgs) != 1 || string(msgs[0]) != "<7>no terminator" {
+		t.Errorf("got %q, wanted <7>no terminator", msgs)
+	}
+}
+
+// An octet counted message longer than maxFrameSize is truncated and the rest of it
+// is discarded without being buffered
+func TestFramingOversizeOctetCount(t *testing.T) {
+	f := newFramer(framingUnknown)
+	n := maxFrameSize + 5000
+	var got []string
+	got = appendFrames(got, f.split([]byte(strconv.Itoa(n)+" <7>")))
+	body := []byte(strings.Repeat("x", n-3))
+	for len(body) > 0 {
+		chunk := body[:min(len(body), 1000)]
+		body = body[len(chunk):]
+		got = appendFrames(got, f.split(chunk))
+		if len(f.buf) > maxFrameSize+1000 {
+			t.Fatalf("buffer has grown to %d bytes", len(f.buf))
+		}
+	}
+	got = appendFrames(got, f.split([]byte("5 <7>ok")))
+	if len(got) != 2 || len(got[0]) != maxFrameSize || !strings.HasPrefix(got[0], "<7>xxx") || got[1] != "<7>ok" {
+		t.Errorf("got %d messages: %.20q", len(got), got)
+	}
+}
+
+// A stream without any framing is split before it grows beyond maxFrameSize, even
+// if it is never idle
+func TestFramingUnboundedStream(t *testing.T) {
+	for _, start := range []string{"", "123456789012 "} { // No framing, or an invalid count
+		f := newFramer(framingUnknown)
+		var got []string
+		got = appendFrames(got, f.split([]byte(start)))
+		for i := 0; i < 100; i++ {
+			got = appendFrames(got, f.split([]byte("<7>"+strings.Repeat("x", 5000))))
+			if len(f.buf) > maxFrameSize+5003 {
+				t.Fatalf("%q: buffer has grown to %d bytes", start, len(f.buf))
+			}
+		}
+		if len(got) < 80 || got[1] != "<7>"+strings.Repeat("x", 5000) {
+			t.Errorf("%q: got %d messages", start, len(got))
+		}
+	}
+}
+
+func appendFrames(r []string, msgs [][]byte) []string {
+	for _, msg := range msgs {
+		r = append(r, string(msg))
+	}
+	return r
+}
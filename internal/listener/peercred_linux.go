@@ -0,0 +1,72 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package listener
+
+import (
+	"net"
+	"syscall"
+)
+
+// Size of the out-of-band buffer needed to receive SCM_CREDENTIALS
+var credentialsOOBSize = syscall.CmsgSpace(syscall.SizeofUcred)
+
+// enablePassCred asks the kernel to attach the sender's credentials to each datagram
+func enablePassCred(c *net.UnixConn) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// parseCredentials extracts SCM_CREDENTIALS from the out-of-band data of a datagram
+func parseCredentials(oob []byte) (credentials, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return credentials{}, false
+	}
+	for i := range msgs {
+		if cred, err := syscall.ParseUnixCredentials(&msgs[i]); err == nil {
+			return credentials{int(cred.Pid), int(cred.Uid), int(cred.Gid)}, true
+		}
+	}
+	return credentials{}, false
+}
+
+// peerCredentials returns the SO_PEERCRED credentials of a stream connection
+func peerCredentials(c *net.UnixConn) (credentials, bool) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return credentials{}, false
+	}
+	var cred *syscall.Ucred
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		cred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || serr != nil {
+		return credentials{}, false
+	}
+	return credentials{int(cred.Pid), int(cred.Uid), int(cred.Gid)}, true
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package listener
+
+import (
+	"net"
+)
+
+// Credentials of local processes are only available on Linux
+var credentialsOOBSize = 0
+
+func enablePassCred(c *net.UnixConn) error {
+	return nil
+}
+
+func parseCredentials(oob []byte) (credentials, bool) {
+	return credentials{}, false
+}
+
+func peerCredentials(c *net.UnixConn) (credentials, bool) {
+	return credentials{}, false
+}
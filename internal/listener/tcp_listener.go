@@ -20,7 +20,6 @@ import (
 	"log"
 	"net"
 	"os"
-	"regexp"
 	"time"
 
 	"github.com/m-z-b/syslogqd/internal/syslog"
@@ -29,16 +28,17 @@ import (
 // ReadTimeout is the timeout for a TCP read
 const ReadTimeout = 1000 * time.Millisecond
 
-// Regex used to identify the start of messages
-var entryStart = regexp.MustCompile(`<[0-9]{2,3}>`)
-
 type TCPListener struct {
 	listener  net.Listener
-	reporting syslog.Channel
+	reporting *syslog.Queue
 }
 
-func NewTCPListener(port int, reporting syslog.Channel) (*TCPListener, error) {
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// NewTCPListener returns a new TCPListener on the given address
+//
+// network is tcp, tcp4 or tcp6 and address is host:port where the host may be
+// empty to listen on all interfaces
+func NewTCPListener(network, address string, reporting *syslog.Queue) (*TCPListener, error) {
+	l, err := net.Listen(network, address)
 	if err != nil {
 		return nil, err
 	}
@@ -50,48 +50,49 @@ func NewTCPListener(port int, reporting syslog.Channel) (*TCPListener, error) {
 	return r, nil
 }
 
-// If we can find a syslog message start after the beginning of the buffer
-// we can treat all bytes up to that point as a message and remove it
-// from the buffer
-func (self *TCPListener) removeComplete(msg []byte, c net.Conn) []byte {
-	if len(msg) > 0 {
-		for loc := entryStart.FindIndex(msg[1:]); loc != nil; loc = entryStart.FindIndex(msg[1:]) {
-			// We have found a <99> at location loc[0]+1
-			self.reporting <- syslog.NewEntry(msg[0:loc[0]+1], c.RemoteAddr())
-			remaining := copy(msg, msg[loc[0]+1:])
-			msg = msg[:remaining] // Note remaining > 0 since matches entryStart
-		}
-	}
-	return msg
+// Accept reads messages from a connection until it is closed
+func (self *TCPListener) Accept(c net.Conn) {
+	readStream(c, newFramer(framingUnknown), self.reporting, nil)
 }
 
-// Read connection and break into bytes based on timeout / or start of syslog header
-// If we knew there would only be a few messages, we could just buffer everything and parse
-// on timeout. As it is, we need to deal with a single read producing a message and possibly
-// a message fragment.
-func (self *TCPListener) Accept(c net.Conn) {
-	msg := make([]byte, 0, 2048)
+// readStream reads messages from a stream until it is closed, and reports them
+//
+// The framing of the messages (octet counting, LF or NUL terminated) is detected
+// from the first message. Partial messages are only reported when the connection is
+// closed, unless no framing could be detected in which case the buffer is reported
+// each time the connection goes quiet.
+//
+// If annotate is not nil, it is called to add connection information to each entry.
+func readStream(c net.Conn, f *framer, reporting *syslog.Queue, annotate func(*syslog.Entry)) {
+	defer c.Close()
+	report := func(msgs [][]byte) {
+		for _, msg := range msgs {
+			if len(msg) > 0 {
+				e := syslog.NewEntry(msg, c.RemoteAddr())
+				if annotate != nil {
+					annotate(e)
+				}
+				reporting.Send(e)
+			}
+		}
+	}
+	buffer := make([]byte, 4096)
 	for {
-		buffer := make([]byte, 1024, 1024)
 		c.SetReadDeadline(time.Now().Add(ReadTimeout))
 		n, err := c.Read(buffer)
 		if n > 0 {
-			msg = append(msg, buffer[:n]...)
-			msg = self.removeComplete(msg, c)
+			report(f.split(buffer[:n]))
 		}
 		if err != nil {
-			msg = self.removeComplete(msg, c)
-			if len(msg) > 0 {
-				self.reporting <- syslog.NewEntry(msg, c.RemoteAddr())
-				msg = msg[:0]
-			}
 			switch {
 			case err == io.EOF:
-				c.Close()
+				report(f.flush(true))
 				return
 			case os.IsTimeout(err):
+				report(f.flush(false))
 				continue
 			default:
+				report(f.flush(true))
 				log.Println(err.Error())
 				return
 			}
@@ -0,0 +1,214 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// HandshakeTimeout is the time allowed for a TLS client to complete the handshake
+const HandshakeTimeout = 10 * time.Second
+
+// TLSListener accepts syslog over TLS connections as described in RFC 5425
+//
+// Messages are expected to use octet counted framing, but other framings are
+// detected in the same way as for a TCPListener.
+//
+// If client certificates are required, the identity from each client's certificate
+// is added to the entries it sends.
+type TLSListener struct {
+	listener  net.Listener
+	reporting *syslog.Queue
+}
+
+// NewTLSListener returns a new TLSListener on the given address using the supplied
+// certificate
+//
+// network is tcp, tcp4 or tcp6 and address is host:port where the host may be
+// empty to listen on all interfaces. If clientCAs is not nil, clients must present
+// a certificate signed by one of them
+func NewTLSListener(network, address string, cert tls.Certificate, clientCAs *x509.CertPool, reporting *syslog.Queue) (*TLSListener, error) {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAs != nil {
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	l, err := tls.Listen(network, address, config)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &TLSListener{
+		listener:  l,
+		reporting: reporting,
+	}
+	return r, nil
+}
+
+// Accept completes the TLS handshake and reads messages from a connection until it is closed
+func (self *TLSListener) Accept(c net.Conn) {
+	tc := c.(*tls.Conn)
+	tc.SetDeadline(time.Now().Add(HandshakeTimeout))
+	if err := tc.Handshake(); err != nil {
+		log.Printf("TLS handshake with %s failed: %s", c.RemoteAddr(), err.Error())
+		c.Close()
+		return
+	}
+	tc.SetDeadline(time.Time{})
+
+	identity := ""
+	if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+		identity = CertificateIdentity(certs[0])
+	}
+	annotate := func(e *syslog.Entry) {
+		e.SetTransport("tls")
+		e.SetIdentity(identity)
+	}
+	readStream(c, newFramer(framingOctetCounted), self.reporting, annotate)
+}
+
+func (self *TLSListener) Listen() {
+	for {
+		conn, err := self.listener.Accept()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		go self.Accept(conn)
+	}
+}
+
+// CertificateIdentity returns the name a client certificate identifies: the
+// subject CN, or if that is empty the first DNS, email, IP or URI SAN
+func CertificateIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.IPAddresses) > 0:
+		return cert.IPAddresses[0].String()
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return cert.Subject.String()
+}
+
+// LoadCertPool loads a bundle of PEM encoded CA certificates
+func LoadCertPool(filename string) (*x509.CertPool, error) {
+	pemCerts, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load CA certificates: %s", err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, fmt.Errorf("no certificates found in %s", filename)
+	}
+	return pool, nil
+}
+
+// LoadCertificate loads a PEM encoded certificate and private key
+//
+// If generate is true and neither file exists, a self-signed certificate is created
+// and saved to the files first. This is intended for testing: devices will need to
+// be told to trust the certificate or not to verify it.
+func LoadCertificate(certFile, keyFile string, generate bool) (tls.Certificate, error) {
+	if generate && !exists(certFile) && !exists(keyFile) {
+		if err := generateCertificate(certFile, keyFile); err != nil {
+			return tls.Certificate{}, fmt.Errorf("unable to generate certificate: %s", err.Error())
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to load certificate: %s", err.Error())
+	}
+	return cert, nil
+}
+
+func exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// generateCertificate creates a self-signed certificate for this host valid for 10 years
+func generateCertificate(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"syslogqd"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{hostname, "localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err = writePEM(keyFile, "PRIVATE KEY", keyDer, 0600); err != nil {
+		return err
+	}
+	return writePEM(certFile, "CERTIFICATE", der, 0644)
+}
+
+func writePEM(filename, blockType string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(f, &pem.Block{Type: blockType, Bytes: der})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
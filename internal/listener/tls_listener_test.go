@@ -0,0 +1,160 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// startTLS starts a TLSListener with a generated certificate, returning its address,
+// the queue it sends entries to and a pool which trusts its certificate
+func startTLS(t *testing.T, clientCAs *x509.CertPool) (string, *syslog.Queue, *x509.CertPool) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert, err := LoadCertificate(certFile, keyFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, err := LoadCertPool(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := syslog.NewQueue(10, syslog.Block)
+	l, err := NewTLSListener("tcp", "127.0.0.1:0", cert, clientCAs, queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.listener.Close() })
+	go l.Listen()
+	return l.listener.Addr().String(), queue, roots
+}
+
+// receive returns the next entry from the queue, or nil if there isn't one
+func receive(queue *syslog.Queue, wait time.Duration) *syslog.Entry {
+	select {
+	case e := <-queue.Entries():
+		return e
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+func TestTLSListener(t *testing.T) {
+	addr, queue, roots := startTLS(t, nil)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	messages := []string{"<13>first message", "<13>second\nmessage with a newline"}
+	for _, m := range messages {
+		if _, err := fmt.Fprintf(conn, "%d %s", len(m), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, wanted := range []string{"first message", "second message with a newline"} {
+		e := receive(queue, 2*time.Second)
+		if e == nil {
+			t.Fatalf("no entry received for %q", wanted)
+		}
+		if e.Message() != wanted || e.Transport() != "tls" || e.Identity() != "" {
+			t.Errorf("got %q from %s (%q), wanted %q", e.Message(), e.Transport(), e.Identity(), wanted)
+		}
+	}
+}
+
+// newCertificate creates a certificate for key signed by parent (self-signed if
+// parent is nil)
+func newCertificate(t *testing.T, template *x509.Certificate, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := newCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}, caKey, nil, nil)
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	client := newCertificate(t, &x509.Certificate{
+		DNSNames:    []string{"sensor-1.example"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, clientKey, ca, caKey)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	addr, queue, roots := startTLS(t, clientCAs)
+
+	// A client without a certificate is rejected
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err == nil {
+		// With TLS 1.3 the client finds out when it next reads
+		fmt.Fprintf(conn, "%d %s", len("<13>anonymous"), "<13>anonymous")
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err == nil {
+		t.Error("client without a certificate was accepted")
+	}
+	if e := receive(queue, 100*time.Millisecond); e != nil {
+		t.Errorf("got %q from a client without a certificate", e.Message())
+	}
+
+	// A client with a certificate is identified by it (here by its DNS name, as it
+	// has no CN)
+	cert := tls.Certificate{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}
+	conn, err = tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%d %s", len("<13>hello"), "<13>hello")
+	e := receive(queue, 2*time.Second)
+	if e == nil {
+		t.Fatal("no entry received")
+	}
+	if e.Message() != "hello" || e.Identity() != "sensor-1.example" {
+		t.Errorf("got %q from %q", e.Message(), e.Identity())
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package listener
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// Number of datagrams read by a single recvmmsg call
+const udpBatchSize = 16
+
+// mmsghdr matches struct mmsghdr in <sys/socket.h>
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// kernelTruncated returns true if the kernel discarded part of a datagram
+func kernelTruncated(flags int) bool {
+	return flags&syscall.MSG_TRUNC != 0
+}
+
+// Listen reads batches of datagrams with recvmmsg so that bursts of messages
+// need fewer system calls. If recvmmsg is not available, datagrams are read
+// one at a time.
+func (self *UDPListener) Listen() {
+	rc, err := self.sock.SyscallConn()
+	if err != nil {
+		self.listenSingle()
+		return
+	}
+
+	size := self.maxMessage + 1 // One more byte so that longer messages can be detected
+	bufs := make([]byte, udpBatchSize*size)
+	iovs := make([]syscall.Iovec, udpBatchSize)
+	names := make([]syscall.RawSockaddrAny, udpBatchSize)
+	msgs := make([]mmsghdr, udpBatchSize)
+	for i := range msgs {
+		iovs[i].Base = &bufs[i*size]
+		iovs[i].SetLen(size)
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.Iovlen = 1
+		msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+	}
+
+	for {
+		var n int
+		var errno syscall.Errno
+		for i := range msgs {
+			msgs[i].hdr.Namelen = syscall.SizeofSockaddrAny
+			msgs[i].hdr.Flags = 0
+		}
+		err = rc.Read(func(fd uintptr) bool {
+			r, _, e := syscall.Syscall6(syscall.SYS_RECVMMSG, fd,
+				uintptr(unsafe.Pointer(&msgs[0])), udpBatchSize, 0, 0, 0)
+			n, errno = int(r), e
+			return errno != syscall.EAGAIN && errno != syscall.EWOULDBLOCK
+		})
+		switch {
+		case err != nil:
+			if isClosed(err) {
+				return
+			}
+			log.Printf("Socket Read Error: %s", err.Error())
+			continue
+		case errno == syscall.ENOSYS:
+			self.listenSingle()
+			return
+		case errno == syscall.EINTR:
+			continue
+		case errno != 0:
+			log.Printf("Socket Read Error: %s", errno.Error())
+			continue
+		}
+		for i := 0; i < n; i++ {
+			buf := bufs[i*size : i*size+int(msgs[i].len)]
+			self.report(buf, sockaddrToUDP(&names[i]), int(msgs[i].hdr.Flags))
+		}
+	}
+}
+
+// sockaddrToUDP converts the address of a sender to a *net.UDPAddr
+func sockaddrToUDP(rsa *syscall.RawSockaddrAny) *net.UDPAddr {
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		sa := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		return &net.UDPAddr{IP: net.IPv4(sa.Addr[0], sa.Addr[1], sa.Addr[2], sa.Addr[3]), Port: int(p[0])<<8 | int(p[1])}
+	case syscall.AF_INET6:
+		sa := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		p := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: int(p[0])<<8 | int(p[1]), Zone: zoneName(sa.Scope_id)}
+	}
+	return &net.UDPAddr{}
+}
+
+// zoneName returns the name of the interface with the index of an IPv6 scope id, or
+// "" for addresses without a scope
+func zoneName(index uint32) string {
+	if index == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(int(index)); err == nil {
+		return ifi.Name
+	}
+	return strconv.FormatUint(uint64(index), 10)
+}
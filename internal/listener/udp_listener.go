@@ -23,50 +23,83 @@ import (
 	"github.com/m-z-b/syslogqd/internal/syslog"
 )
 
-// Maximum number of bytes we will accept in a UDP message - although in theory this
-// is 65527 (+ 8 bytes header ) - in practice a packet that size will be split
-const maxUDPmessage = 512
+// Maximum number of bytes we will accept in a UDP message - the largest possible
+// UDP payload is 65527 bytes (65535 less the 8 byte header), and for IPv4 it is
+// less. Larger (jumbo) datagrams are truncated and flagged.
+const maxUDPmessage = 65535 - 8
 
 // UDPListener listens for incoming syslog messages
 //
 // Create with NewUDPListener(), then
 // call the Listen() function to handle syslog messages
 type UDPListener struct {
-	port      int
-	sock      *net.UDPConn
-	reporting syslog.Channel
+	address    string
+	sock       *net.UDPConn
+	reporting  *syslog.Queue
+	maxMessage int // Longer datagrams are truncated
 }
 
-// NewUDPListener returns a new UDPListener on the given port
-// If the listener can't be created, an error is returned as
-// the second return value
-func NewUDPListener(port int, reporting syslog.Channel) (*UDPListener, error) {
-	u := UDPListener{port: port, reporting: reporting}
+// NewUDPListener returns a new UDPListener on the given address
+//
+// network is udp, udp4 or udp6 and address is host:port where the host may be
+// empty to listen on all interfaces. If the listener can't be created, an error
+// is returned as the second return value
+func NewUDPListener(network, address string, reporting *syslog.Queue) (*UDPListener, error) {
+	u := UDPListener{address: address, reporting: reporting, maxMessage: maxUDPmessage}
 
 	var err error
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
+	addr, err := net.ResolveUDPAddr(network, address)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("unable to resolve UDP port %d: %s", port, err.Error()))
+		return nil, errors.New(fmt.Sprintf("unable to resolve UDP address %s: %s", address, err.Error()))
 	}
-	u.sock, err = net.ListenUDP("udp", addr)
+	u.sock, err = net.ListenUDP(network, addr)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("unable to listen to UDP port %d: %s\n", port, err.Error()))
+		return nil, errors.New(fmt.Sprintf("unable to listen to UDP address %s: %s", address, err.Error()))
 	}
 	return &u, nil
 }
 
-func (self *UDPListener) Listen() {
+// SetReadBuffer sets the size of the operating system's receive buffer (SO_RCVBUF)
+//
+// A larger buffer allows bursts of messages to be queued by the kernel rather than dropped
+func (self *UDPListener) SetReadBuffer(bytes int) error {
+	return self.sock.SetReadBuffer(bytes)
+}
+
+// listenSingle reads one datagram at a time, reusing the same buffer
+func (self *UDPListener) listenSingle() {
+	buf := make([]byte, self.maxMessage+1) // One more byte so that longer messages can be detected
 	for {
-		buf := make([]byte, 512) // TODO Check max UDP. Reuse of buffers
-		nBytes, remoteAddress, err := self.sock.ReadFromUDP(buf)
+		nBytes, _, flags, remoteAddress, err := self.sock.ReadMsgUDP(buf, nil)
 		if err != nil {
+			if isClosed(err) {
+				return
+			}
 			log.Printf("Socket Read Error: %s", err.Error())
 			continue
 		}
-		for ; nBytes > 0 && buf[nBytes-1] == '\n'; nBytes-- {
-		}
-		if nBytes > 0 {
-			self.reporting <- syslog.NewEntry(buf[0:nBytes], remoteAddress)
+		self.report(buf[:nBytes], remoteAddress, flags)
+	}
+}
+
+// report sends a datagram as a syslog entry, truncating it if it is longer than the
+// maximum message size. flags are those returned by the read.
+//
+// The buffer is not retained so can be reused
+func (self *UDPListener) report(buf []byte, remoteAddress net.Addr, flags int) {
+	truncated := kernelTruncated(flags)
+	if len(buf) > self.maxMessage {
+		buf = buf[:self.maxMessage]
+		truncated = true
+	}
+	nBytes := len(buf)
+	for ; nBytes > 0 && buf[nBytes-1] == '\n'; nBytes-- {
+	}
+	if nBytes > 0 {
+		e := syslog.NewEntry(buf[0:nBytes], remoteAddress)
+		if truncated {
+			e.SetTruncated()
 		}
+		self.reporting.Send(e)
 	}
 }
@@ -0,0 +1,77 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// testUDP sends datagrams to a listener with a 100 byte message limit which reads them
+// using listen, and checks the entries received
+func testUDP(t *testing.T, network, address string, listen func(l *UDPListener)) {
+	queue := syslog.NewQueue(10, syslog.Block)
+	l, err := NewUDPListener(network, address, queue)
+	if err != nil {
+		t.Skipf("can't listen on %s: %s", address, err)
+	}
+	defer l.sock.Close()
+	l.maxMessage = 100
+	go listen(l)
+
+	conn, err := net.Dial(network, l.sock.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+
+	long := "<13>" + strings.Repeat("x", 200)
+	for _, ex := range []struct {
+		sent      string
+		text      string
+		truncated bool
+	}{
+		{"<13>short message\n", "short message", false},
+		{long[:100], long[4:100], false},
+		{long, long[4:100] + " [truncated]", true},
+	} {
+		if _, err := conn.Write([]byte(ex.sent)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case e := <-queue.Entries():
+			if e.Message() != ex.text || e.Truncated() != ex.truncated {
+				t.Errorf("%s: sent %d bytes: got %q, truncated %v", address, len(ex.sent), e.Message(), e.Truncated())
+			}
+			if e.RemoteIP() != local.IP.String() || e.RemotePort() != local.Port {
+				t.Errorf("%s: got sender %s:%d, wanted %s", address, e.RemoteIP(), e.RemotePort(), local)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no entry received", address)
+		}
+	}
+}
+
+func TestUDPListener(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:0", "[::1]:0"} {
+		testUDP(t, "udp", address, (*UDPListener).Listen)
+		testUDP(t, "udp", address, (*UDPListener).listenSingle)
+	}
+}
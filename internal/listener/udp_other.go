@@ -0,0 +1,27 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package listener
+
+// kernelTruncated returns false: truncation is detected from the length of the datagram
+func kernelTruncated(flags int) bool {
+	return false
+}
+
+// Listen reads datagrams one at a time: batched reads are only supported on Linux
+func (self *UDPListener) Listen() {
+	self.listenSingle()
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// Maximum number of bytes we will accept in a unix datagram
+const maxUnixMessage = 64 * 1024
+
+// UnixListener listens for syslog messages from local processes on a unix
+// domain socket, like /dev/log
+//
+// Where the operating system supports it, the pid, uid and gid of the sending
+// process are added to each entry.
+type UnixListener struct {
+	path      string
+	sock      *net.UnixConn     // Datagram socket
+	listener  *net.UnixListener // Stream socket
+	reporting *syslog.Queue
+}
+
+// credentials of a local process
+type credentials struct {
+	pid, uid, gid int
+}
+
+// NewUnixListener returns a new UnixListener bound to the given path
+//
+// network is "unixgram" for a datagram socket or "unix" for a stream socket.
+// Any existing socket at path is replaced, and the new socket can be written to by
+// any user.
+func NewUnixListener(network, path string, reporting *syslog.Queue) (*UnixListener, error) {
+	u := UnixListener{path: path, reporting: reporting}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path) // Left over from a previous run
+	}
+	addr := &net.UnixAddr{Name: path, Net: network}
+	var err error
+	switch network {
+	case "unixgram":
+		if u.sock, err = net.ListenUnixgram(network, addr); err == nil {
+			if err = enablePassCred(u.sock); err != nil {
+				u.sock.Close()
+			}
+		}
+	case "unix":
+		u.listener, err = net.ListenUnix(network, addr)
+	default:
+		return nil, fmt.Errorf("unknown unix socket type %q", network)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %s", path, err.Error())
+	}
+	if err = os.Chmod(path, 0666); err != nil {
+		u.Close()
+		return nil, fmt.Errorf("unable to set permissions of %s: %s", path, err.Error())
+	}
+	return &u, nil
+}
+
+// Close closes the socket and removes it from the file system
+func (self *UnixListener) Close() error {
+	var err error
+	if self.sock != nil {
+		err = self.sock.Close()
+	}
+	if self.listener != nil {
+		err = self.listener.Close() // Also removes the socket
+	}
+	os.Remove(self.path)
+	return err
+}
+
+func (self *UnixListener) Listen() {
+	if self.listener != nil {
+		self.listenStream()
+	} else {
+		self.listenDatagram()
+	}
+}
+
+func (self *UnixListener) listenDatagram() {
+	buf := make([]byte, maxUnixMessage)
+	oob := make([]byte, credentialsOOBSize)
+	for {
+		nBytes, oobn, _, remoteAddress, err := self.sock.ReadMsgUnix(buf, oob)
+		if err != nil {
+			if isClosed(err) {
+				return
+			}
+			log.Printf("Socket Read Error: %s", err.Error())
+			continue
+		}
+		for ; nBytes > 0 && (buf[nBytes-1] == '\n' || buf[nBytes-1] == 0); nBytes-- {
+		}
+		if nBytes > 0 {
+			e := syslog.NewEntry(buf[0:nBytes], remoteAddress)
+			e.SetTransport("unixgram")
+			if cred, ok := parseCredentials(oob[:oobn]); ok {
+				e.SetCredentials(cred.pid, cred.uid, cred.gid)
+			}
+			self.reporting.Send(e)
+		}
+	}
+}
+
+func (self *UnixListener) listenStream() {
+	for {
+		conn, err := self.listener.AcceptUnix()
+		if err != nil {
+			if !isClosed(err) {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return
+		}
+		cred, ok := peerCredentials(conn)
+		annotate := func(e *syslog.Entry) {
+			e.SetTransport("unix")
+			if ok {
+				e.SetCredentials(cred.pid, cred.uid, cred.gid)
+			}
+		}
+		go readStream(conn, newFramer(framingUnknown), self.reporting, annotate)
+	}
+}
+
+// isClosed returns true if err is the result of using a closed socket
+func isClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// testUnix sends messages to a unix socket listener, as logger does without binding
+// its own socket, and checks the entries received
+func testUnix(t *testing.T, network string, sent string, wanted ...string) {
+	queue := syslog.NewQueue(10, syslog.Block)
+	path := filepath.Join(t.TempDir(), "log")
+	l, err := NewUnixListener(network, path, queue)
+	if err != nil {
+		t.Skipf("can't listen on %s %s: %s", network, path, err)
+	}
+	defer l.Close()
+	go l.Listen()
+
+	conn, err := net.Dial(network, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(sent)); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range wanted {
+		select {
+		case e := <-queue.Entries():
+			if e.Message() != text || e.Transport() != network {
+				t.Errorf("%s: got %q from %s, wanted %q", network, e.Message(), e.Transport(), text)
+			}
+			pid, _, _, ok := e.Credentials()
+			if runtime.GOOS == "linux" && (!ok || pid != os.Getpid()) {
+				t.Errorf("%s: got pid %d (%v), wanted %d", network, pid, ok, os.Getpid())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: no entry received", network)
+		}
+	}
+}
+
+func TestUnixDatagramListener(t *testing.T) {
+	testUnix(t, "unixgram", "<13>local message\n", "local message")
+}
+
+func TestUnixStreamListener(t *testing.T) {
+	testUnix(t, "unix", "<13>first\n<13>second\n", "first", "second")
+}
@@ -0,0 +1,366 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logfile provides an output file which can be rotated by size or time
+package logfile
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval is how often a File is rotated regardless of its size
+type Interval uint8
+
+const (
+	Never Interval = iota
+	Hourly
+	Daily
+)
+
+var intervalNames = []string{"never", "hourly", "daily"}
+
+func (i Interval) String() string {
+	if int(i) < len(intervalNames) {
+		return intervalNames[i]
+	}
+	return fmt.Sprintf("interval(%d)!", int(i))
+}
+
+// ParseInterval converts a string such as "daily" into an Interval
+func ParseInterval(s string) (Interval, error) {
+	if s == "" {
+		return Never, nil
+	}
+	for i, name := range intervalNames {
+		if strings.EqualFold(s, name) {
+			return Interval(i), nil
+		}
+	}
+	return Never, fmt.Errorf("Unknown rotation interval \"%s\" (should be one of %s)", s, strings.Join(intervalNames, ", "))
+}
+
+// start returns the start of the interval containing t
+func (i Interval) start(t time.Time) time.Time {
+	switch i {
+	case Hourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case Daily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// ParseSize converts a size such as 500K, 100M or 2G into a number of bytes
+func ParseSize(s string) (int64, error) {
+	digits := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	multiplier := int64(1)
+	if n := len(digits); n > 0 {
+		switch digits[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			digits = digits[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size \"%s\" (should be a number of bytes, optionally followed by K, M or G)", s)
+	}
+	return n * multiplier, nil
+}
+
+// Options controls when and how a File is rotated
+type Options struct {
+	MaxSize  int64    // Rotate before the file grows beyond this many bytes (0 = no limit)
+	Interval Interval // Rotate when the hour or day changes
+	Pattern  string   // strftime-like name for rotated files, relative to the file's directory
+	Keep     int      // Number of rotated files to keep (0 = keep them all)
+	Compress bool     // gzip rotated files
+	OnError  func(error)
+}
+
+// DefaultPattern returns the pattern used for rotated files if Options.Pattern is empty
+func DefaultPattern(name string) string {
+	return filepath.Base(name) + ".%Y%m%d-%H%M%S"
+}
+
+// A File is an append-only output file which is rotated according to its Options
+//
+// When a File is rotated, it is renamed using the pattern and the time its
+// contents started, and a new file is created. Rotated files are then compressed
+// and old ones removed in the background. Reopen supports external tools such as
+// logrotate which rename the file themselves.
+//
+// A File may be used from several goroutines at once.
+type File struct {
+	mu          sync.Mutex
+	name        string
+	options     Options
+	f           *os.File // nil once closed
+	size        int64
+	started     time.Time // When the current contents were started
+	period      time.Time // Start of the interval containing started
+	maintenance sync.Mutex
+	background  sync.WaitGroup
+}
+
+// Open opens or creates a file for appending
+func Open(name string, options Options) (*File, error) {
+	if options.Pattern == "" {
+		options.Pattern = DefaultPattern(name)
+	}
+	if options.OnError == nil {
+		options.OnError = func(error) {}
+	}
+	self := &File{name: name, options: options}
+	if err := self.open(); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+// Name returns the name of the file
+func (self *File) Name() string {
+	return self.name
+}
+
+// open opens the named file, noting its size and when its contents started
+func (self *File) open() error {
+	f, err := os.OpenFile(self.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	self.f = f
+	self.size = 0
+	self.started = time.Now()
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		// The best guess for an existing file is when it was last written
+		self.size = fi.Size()
+		self.started = fi.ModTime()
+	}
+	self.period = self.options.Interval.start(self.started)
+	return nil
+}
+
+// Write appends p to the file, first rotating it if necessary
+func (self *File) Write(p []byte) (int, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.f == nil {
+		return 0, os.ErrClosed
+	}
+	if self.rotationDue(len(p)) {
+		if err := self.rotate(); err != nil {
+			self.options.OnError(err)
+			if self.f == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := self.f.Write(p)
+	self.size += int64(n)
+	return n, err
+}
+
+// rotationDue returns true if n more bytes should be written to a new file
+func (self *File) rotationDue(n int) bool {
+	if self.size == 0 {
+		return false
+	}
+	if self.options.MaxSize > 0 && self.size+int64(n) > self.options.MaxSize {
+		return true
+	}
+	return self.options.Interval != Never && !self.options.Interval.start(time.Now()).Equal(self.period)
+}
+
+// Rotate renames the file and starts a new one
+func (self *File) Rotate() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.f == nil {
+		return os.ErrClosed
+	}
+	return self.rotate()
+}
+
+func (self *File) rotate() error {
+	if err := self.f.Close(); err != nil {
+		self.options.OnError(err)
+	}
+	self.f = nil
+	rotated := self.rotatedName()
+	renameErr := os.Rename(self.name, rotated)
+	if err := self.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("Could not rotate %s: %w", self.name, renameErr)
+	}
+	if self.options.Compress || self.options.Keep > 0 {
+		self.background.Add(1)
+		go self.tidy(rotated)
+	}
+	return nil
+}
+
+// rotatedName returns an unused name for the current contents
+func (self *File) rotatedName() string {
+	name := strftime(self.options.Pattern, self.started)
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(self.name), name)
+	}
+	unique := name
+	for i := 1; exists(unique) || exists(unique+".gz"); i++ {
+		unique = fmt.Sprintf("%s.%d", name, i)
+	}
+	return unique
+}
+
+// Reopen closes and reopens the file, which may have been renamed by another program
+func (self *File) Reopen() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.f == nil {
+		return os.ErrClosed
+	}
+	self.f.Close()
+	self.f = nil
+	return self.open()
+}
+
+// Close closes the file and waits for any compression to finish
+func (self *File) Close() error {
+	self.mu.Lock()
+	var err error
+	if self.f != nil {
+		err = self.f.Close()
+		self.f = nil
+	}
+	self.mu.Unlock()
+	self.background.Wait()
+	return err
+}
+
+// tidy compresses a rotated file and removes the oldest rotated files
+func (self *File) tidy(rotated string) {
+	defer self.background.Done()
+	self.maintenance.Lock()
+	defer self.maintenance.Unlock()
+	if self.options.Compress {
+		if err := compress(rotated); err != nil {
+			self.options.OnError(err)
+		}
+	}
+	if self.options.Keep > 0 {
+		if err := self.prune(); err != nil {
+			self.options.OnError(err)
+		}
+	}
+}
+
+// prune removes all but the newest Keep rotated files
+func (self *File) prune() error {
+	glob := strftimeGlob(self.options.Pattern)
+	if !filepath.IsAbs(glob) {
+		glob = filepath.Join(filepath.Dir(self.name), glob)
+	}
+	// The trailing * matches compressed files and names made unique
+	matches, err := filepath.Glob(glob + "*")
+	if err != nil {
+		return err
+	}
+	type rotatedFile struct {
+		name    string
+		modTime time.Time
+	}
+	var files []rotatedFile
+	current, _ := filepath.Abs(self.name)
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if abs, _ := filepath.Abs(m); err != nil || !fi.Mode().IsRegular() || abs == current {
+			continue
+		}
+		files = append(files, rotatedFile{m, fi.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.After(files[j].modTime)
+		}
+		// Names made unique within the same second: name.2 is newer than name.1 or name
+		if len(files[i].name) != len(files[j].name) {
+			return len(files[i].name) > len(files[j].name)
+		}
+		return files[i].name > files[j].name
+	})
+	var errs []error
+	for i := self.options.Keep; i < len(files); i++ {
+		errs = append(errs, os.Remove(files[i].name))
+	}
+	return errors.Join(errs...)
+}
+
+// compress replaces a file with a gzipped copy, keeping its modification time
+func compress(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	z := gzip.NewWriter(out)
+	z.Name = filepath.Base(name)
+	z.ModTime = fi.ModTime()
+	_, err = io.Copy(z, in)
+	if err == nil {
+		err = z.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return fmt.Errorf("Could not compress %s: %w", name, err)
+	}
+	in.Close()
+	os.Chtimes(name+".gz", fi.ModTime(), fi.ModTime())
+	return os.Remove(name)
+}
+
+// exists returns true if there is a file with the given name
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
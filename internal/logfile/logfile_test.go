@@ -0,0 +1,151 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logfile_test
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/logfile"
+)
+
+// files returns the names of the files in dir
+func files(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "out.log")
+	f, err := logfile.Open(name, logfile.Options{MaxSize: 10, Pattern: "out-%Y.log", Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	got := files(t, dir)
+	if len(got) != 3 {
+		t.Fatalf("expected the file and 2 rotated files, got %v", got)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "fourth\n" {
+		t.Errorf("current file contains %q", b)
+	}
+	year := time.Now().Format("2006")
+	kept := ""
+	for _, g := range got {
+		if g != "out.log" && !strings.HasPrefix(g, "out-"+year+".log") {
+			t.Errorf("unexpected rotated file name %s", g)
+		}
+		if g != "out.log" {
+			b, _ := os.ReadFile(filepath.Join(dir, g))
+			kept += string(b)
+		}
+	}
+	if strings.Contains(kept, "first") || !strings.Contains(kept, "second") || !strings.Contains(kept, "third") {
+		t.Errorf("wrong rotated files kept: %q", kept)
+	}
+}
+
+func TestRotateDaily(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "out.log")
+	os.WriteFile(name, []byte("yesterday\n"), 0644)
+	yesterday := time.Now().Add(-24 * time.Hour)
+	os.Chtimes(name, yesterday, yesterday)
+
+	f, err := logfile.Open(name, logfile.Options{Interval: logfile.Daily, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("today\n"))
+	f.Write([]byte("today again\n"))
+	f.Close()
+
+	rotated := filepath.Join(dir, "out.log."+yesterday.Format("20060102-150405")+".gz")
+	z, err := os.Open(rotated)
+	if err != nil {
+		t.Fatalf("rotated file not found: %v", files(t, dir))
+	}
+	defer z.Close()
+	r, err := gzip.NewReader(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(r); string(b) != "yesterday\n" {
+		t.Errorf("rotated file contains %q", b)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "today\ntoday again\n" {
+		t.Errorf("current file contains %q", b)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "out.log")
+	f, err := logfile.Open(name, logfile.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("before\n"))
+	os.Rename(name, name+".1") // As logrotate would
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("after\n"))
+	if b, _ := os.ReadFile(name); string(b) != "after\n" {
+		t.Errorf("reopened file contains %q", b)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, wanted := range map[string]int64{"100": 100, "4k": 4096, "10M": 10 << 20, "1GB": 1 << 30, "2MiB": 2 << 20} {
+		if got, err := logfile.ParseSize(s); err != nil || got != wanted {
+			t.Errorf("ParseSize(%s) = %d, %v: wanted %d", s, got, err, wanted)
+		}
+	}
+	for _, s := range []string{"", "M", "-1", "10X"} {
+		if _, err := logfile.ParseSize(s); err == nil {
+			t.Errorf("ParseSize(%s) should fail", s)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	if i, err := logfile.ParseInterval("Hourly"); err != nil || i != logfile.Hourly {
+		t.Errorf("got %v, %v", i, err)
+	}
+	if _, err := logfile.ParseInterval("weekly"); err == nil {
+		t.Error("expected an error for an unknown interval")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logfile
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// strftime formats t using a pattern with strftime-like directives:
+//
+//	%Y year   %y 2-digit year   %m month   %d day   %j day of year
+//	%H hour   %M minute         %S second  %s Unix time   %% a percent sign
+//
+// Other characters, including unknown directives, are copied unchanged
+func strftime(pattern string, t time.Time) string {
+	b := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 's':
+			fmt.Fprintf(&b, "%d", t.Unix())
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
+
+// strftimeGlob converts a pattern into a glob which matches any file name it can produce
+func strftimeGlob(pattern string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '%' && i+1 < len(pattern) && strings.IndexByte("YymdjHMSs", pattern[i+1]) >= 0:
+			b.WriteByte('*')
+			i++
+		case c == '%' && i+1 < len(pattern) && pattern[i+1] == '%':
+			b.WriteByte('%')
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
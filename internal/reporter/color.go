@@ -0,0 +1,131 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/m-z-b/syslogqd/internal/severity"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// ANSI escape sequences
+const (
+	ansiReset     = "\x1b[0m"
+	ansiRed       = "\x1b[31m"
+	ansiBoldRed   = "\x1b[1;31m"
+	ansiYellow    = "\x1b[33m"
+	ansiDim       = "\x1b[2m"
+	ansiHighlight = "\x1b[7m" // Reverse video
+)
+
+// Colours used for remote IP addresses - red and yellow are left for severities
+var ipColors = []string{
+	"\x1b[32m", "\x1b[34m", "\x1b[35m", "\x1b[36m",
+	"\x1b[92m", "\x1b[94m", "\x1b[95m", "\x1b[96m",
+}
+
+// UseColor decides whether to colour output to a file given a mode of auto, always or never
+//
+// In auto mode, colour is used if the file is a terminal and the NO_COLOR environment
+// variable is not set
+func UseColor(mode string, f *os.File) (bool, error) {
+	switch strings.ToLower(mode) {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(f), nil
+	}
+	return false, fmt.Errorf("Unknown color mode \"%s\" (should be one of auto, always, never)", mode)
+}
+
+// A Colorizer adds ANSI colours to formatted entries
+//
+// The line is coloured by severity (red for error and worse, yellow for warning, dim
+// for debug), the remote IP address is given a colour chosen from its value so each
+// device is always the same colour, and text matching any highlight is shown in
+// reverse video.
+type Colorizer struct {
+	highlight *regexp.Regexp // nil if there are no highlights
+}
+
+// NewColorizer returns a Colorizer which highlights text matching any of the regexes
+func NewColorizer(highlights []*regexp.Regexp) *Colorizer {
+	c := &Colorizer{}
+	if len(highlights) > 0 {
+		// A single regex so that one highlight can't match the escape codes of another
+		alternatives := make([]string, len(highlights))
+		for i, h := range highlights {
+			alternatives[i] = "(?:" + h.String() + ")"
+		}
+		c.highlight = regexp.MustCompile(strings.Join(alternatives, "|"))
+	}
+	return c
+}
+
+// Colorize returns the formatted line for the entry with colours added
+func (self *Colorizer) Colorize(e *syslog.Entry, line string) string {
+	lineColor := ""
+	if e.HasSeverity() {
+		switch s := e.Severity(); {
+		case s.AsOrMoreSevereThan(severity.Severity(2)): // critical
+			lineColor = ansiBoldRed
+		case s.AsOrMoreSevereThan(severity.Severity(3)): // error
+			lineColor = ansiRed
+		case s == severity.Severity(4): // warning
+			lineColor = ansiYellow
+		case s == severity.Severity(7): // debug
+			lineColor = ansiDim
+		}
+	}
+
+	b := strings.Builder{}
+	b.WriteString(lineColor)
+	ip := e.RemoteIP()
+	if i := strings.Index(line, ip); ip != "" && i >= 0 {
+		b.WriteString(self.highlighted(line[:i], lineColor))
+		b.WriteString(ipColor(ip))
+		b.WriteString(ip)
+		b.WriteString(ansiReset)
+		b.WriteString(lineColor)
+		line = line[i+len(ip):]
+	}
+	b.WriteString(self.highlighted(line, lineColor))
+	b.WriteString(ansiReset)
+	return b.String()
+}
+
+// highlighted marks any highlights in s, restoring the line colour after each one
+func (self *Colorizer) highlighted(s string, lineColor string) string {
+	if self.highlight == nil {
+		return s
+	}
+	return self.highlight.ReplaceAllStringFunc(s, func(m string) string {
+		return ansiHighlight + m + ansiReset + lineColor
+	})
+}
+
+// ipColor chooses a colour for an IP address
+func ipColor(ip string) string {
+	h := fnv.New32a()
+	h.Write([]byte(ip))
+	return ipColors[h.Sum32()%uint32(len(ipColors))]
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter_test
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/m-z-b/syslogqd/internal/reporter"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func TestColorize(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	c := reporter.NewColorizer([]*regexp.Regexp{regexp.MustCompile("pan+ic"), regexp.MustCompile("m")})
+
+	e := syslog.NewEntry([]byte("<11>kernel panic"), addr)
+	got := c.Colorize(e, e.String())
+	if !strings.HasPrefix(got, "\x1b[31m") || !strings.HasSuffix(got, "\x1b[0m") {
+		t.Errorf("error not coloured red: %q", got)
+	}
+	if !strings.Contains(got, "\x1b[7mpanic\x1b[0m\x1b[31m") {
+		t.Errorf("highlight not shown: %q", got)
+	}
+	if !strings.Contains(got, "192.168.1.99\x1b[0m") {
+		t.Errorf("IP address not coloured: %q", got)
+	}
+
+	// Same IP, same colour
+	e2 := syslog.NewEntry([]byte("<15>debug"), addr)
+	got2 := c.Colorize(e2, e2.String())
+	ipColor := func(s string) string { return s[strings.Index(s, "192.168.1.99")-5 : strings.Index(s, "192.168.1.99")] }
+	if !strings.HasPrefix(got2, "\x1b[2m") || ipColor(got) != ipColor(got2) {
+		t.Errorf("debug not dim or IP colour not consistent: %q %q", got, got2)
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	if c, err := reporter.UseColor("always", nil); !c || err != nil {
+		t.Error("always should use colour")
+	}
+	if c, err := reporter.UseColor("never", nil); c || err != nil {
+		t.Error("never should not use colour")
+	}
+	if _, err := reporter.UseColor("sometimes", nil); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
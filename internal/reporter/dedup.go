@@ -0,0 +1,126 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// Numbers matches decimal and hexadecimal numbers, for ignoring counters, addresses
+// and times when looking for duplicates
+var Numbers = regexp.MustCompile(`\b0[xX][0-9a-fA-F]+\b|[0-9]+`)
+
+// A Deduplicator collapses identical messages from the same source
+//
+// The first message is reported, and identical messages which arrive within the
+// window are counted rather than reported. When the window ends, a summary entry
+// such as "last message repeated 57 times over 4.2s" is reported if there were any
+// repeats. Messages are identical if they have the same sender, severity, facility
+// and message, ignoring any text matching the ignore regex.
+//
+// A Deduplicator is not safe for use by more than one goroutine.
+type Deduplicator struct {
+	window time.Duration
+	ignore *regexp.Regexp // nil to compare messages exactly
+	seen   map[string]*repeats
+}
+
+// repeats counts the repeats of a message in the current window
+type repeats struct {
+	first *syslog.Entry
+	start time.Time // When first was reported
+	last  time.Time // When the last repeat arrived
+	count int
+}
+
+// NewDeduplicator returns a Deduplicator which ignores text matching ignore (which may
+// be nil) when comparing messages
+func NewDeduplicator(window time.Duration, ignore *regexp.Regexp) *Deduplicator {
+	return &Deduplicator{window: window, ignore: ignore, seen: make(map[string]*repeats)}
+}
+
+// Window returns how long repeats are counted for
+func (self *Deduplicator) Window() time.Duration {
+	return self.window
+}
+
+// key returns the values which must be the same for messages to be identical
+func (self *Deduplicator) key(e *syslog.Entry) string {
+	message := e.Message()
+	if self.ignore != nil {
+		message = self.ignore.ReplaceAllLiteralString(message, "#")
+	}
+	sev := "-"
+	if e.HasSeverity() {
+		sev = fmt.Sprintf("%d/%d", e.Severity(), e.Facility())
+	}
+	return strings.Join([]string{e.RemoteIP(), e.Transport(), sev, message}, "\x00")
+}
+
+// Check returns true if the entry should be reported, or false if it repeats a message
+// reported less than a window ago. If the entry starts a new window for a message
+// whose previous window had repeats which haven't been summarised by Expired yet,
+// the summary is returned and should be reported before the entry.
+func (self *Deduplicator) Check(e *syslog.Entry, now time.Time) (report bool, summary *syslog.Entry) {
+	k := self.key(e)
+	r, ok := self.seen[k]
+	if ok && now.Sub(r.start) < self.window {
+		r.count++
+		r.last = now
+		return false, nil
+	}
+	if ok && r.count > 0 {
+		summary = r.summary()
+	}
+	self.seen[k] = &repeats{first: e, start: now}
+	return true, summary
+}
+
+// Expired forgets messages whose window has ended by now, returning summaries for
+// any which were repeated in the order they were first seen. If all is true, all
+// windows are ended.
+func (self *Deduplicator) Expired(now time.Time, all bool) []*syslog.Entry {
+	var ended []*repeats
+	for k, r := range self.seen {
+		if all || now.Sub(r.start) >= self.window {
+			delete(self.seen, k)
+			if r.count > 0 {
+				ended = append(ended, r)
+			}
+		}
+	}
+	sort.Slice(ended, func(i, j int) bool { return ended[i].start.Before(ended[j].start) })
+	summaries := make([]*syslog.Entry, len(ended))
+	for i, r := range ended {
+		summaries[i] = r.summary()
+	}
+	return summaries
+}
+
+// summary returns an entry reporting the number of repeats
+func (self *repeats) summary() *syslog.Entry {
+	times := "times"
+	if self.count == 1 {
+		times = "time"
+	}
+	return syslog.NewSyntheticEntry(self.first,
+		fmt.Sprintf("last message repeated %d %s over %s", self.count, times, self.last.Sub(self.start).Round(time.Millisecond)))
+}
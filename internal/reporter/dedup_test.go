@@ -0,0 +1,114 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/reporter"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func TestDeduplicator(t *testing.T) {
+	a, _ := net.ResolveUDPAddr("udp", "10.0.0.1:514")
+	b, _ := net.ResolveUDPAddr("udp", "10.0.0.2:514")
+	d := reporter.NewDeduplicator(10*time.Second, reporter.Numbers)
+	start := time.Now()
+	at := func(seconds float64) time.Time { return start.Add(time.Duration(seconds * float64(time.Second))) }
+
+	for _, ex := range []struct {
+		when  float64
+		addr  *net.UDPAddr
+		raw   string
+		wants bool
+	}{
+		{0, a, "<11>retry 1 failed at 0x1f00", true},
+		{1, a, "<11>retry 2 failed at 0x2f00", false}, // Numbers are ignored
+		{2, b, "<11>retry 3 failed at 0x3f00", true},  // Different source
+		{3, a, "<12>retry 4 failed at 0x4f00", true},  // Different severity
+		{4.5, a, "<11>retry 5 failed at 0x5f00", false},
+		{5, a, "<11>something else", true},
+	} {
+		if got, _ := d.Check(syslog.NewEntry([]byte(ex.raw), ex.addr), at(ex.when)); got != ex.wants {
+			t.Errorf("%s at %v: got %v, wanted %v", ex.raw, ex.when, got, ex.wants)
+		}
+	}
+
+	if summaries := d.Expired(at(9), false); len(summaries) != 0 {
+		t.Errorf("window should not have ended: %v", summaries)
+	}
+	summaries := d.Expired(at(10), false)
+	if len(summaries) != 1 || summaries[0].RemoteIP() != "10.0.0.1" || summaries[0].Text() != "last message repeated 2 times over 4.5s" {
+		t.Fatalf("got summaries %v", summaries)
+	}
+
+	// After the window, the message is reported again
+	if report, _ := d.Check(syslog.NewEntry([]byte("<11>retry 6 failed at 0x6f00"), a), at(11)); !report {
+		t.Error("message should be reported in a new window")
+	}
+	d.Check(syslog.NewEntry([]byte("<11>retry 7 failed at 0x6f00"), a), at(12))
+	if summaries := d.Expired(at(12), true); len(summaries) != 1 || !strings.Contains(summaries[0].Text(), "repeated 1 time over 1s") {
+		t.Errorf("got summaries %v", summaries)
+	}
+}
+
+// A repeat which arrives after the window has ended, but before Expired is called,
+// starts a new window and returns the summary of the old one
+func TestDeduplicatorWindowBoundary(t *testing.T) {
+	a, _ := net.ResolveUDPAddr("udp", "10.0.0.1:514")
+	d := reporter.NewDeduplicator(10*time.Second, nil)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		d.Check(syslog.NewEntry([]byte("<11>disk full"), a), start.Add(time.Duration(i)*time.Second))
+	}
+	report, summary := d.Check(syslog.NewEntry([]byte("<11>disk full"), a), start.Add(10*time.Second+time.Millisecond))
+	if !report || summary == nil || summary.Text() != "last message repeated 9 times over 9s" {
+		t.Fatalf("got %v, summary %v", report, summary)
+	}
+	if summaries := d.Expired(start.Add(21*time.Second), false); len(summaries) != 0 {
+		t.Errorf("the new window has no repeats, but got %v", summaries)
+	}
+}
+
+func TestStopReportsRepeats(t *testing.T) {
+	a, _ := net.ResolveUDPAddr("udp", "10.0.0.1:514")
+	var out strings.Builder
+	r := reporter.NewReporter(nil).AddOutput(&out, reporter.TextFormat{}).
+		Deduplicate(reporter.NewDeduplicator(time.Hour, nil))
+	newswire := make(syslog.Channel, 10)
+	for range 3 {
+		newswire <- syslog.NewEntry([]byte("<11>disk full"), a)
+	}
+	go r.Report(newswire, 7)
+	r.Stop()
+	if r.Written() != 2 || r.Suppressed() != 2 || !strings.Contains(out.String(), "last message repeated 2 times") {
+		t.Errorf("wrote %d, suppressed %d:\n%s", r.Written(), r.Suppressed(), out.String())
+	}
+}
+
+func TestDeduplicatorExact(t *testing.T) {
+	d := reporter.NewDeduplicator(time.Minute, nil)
+	now := time.Now()
+	d.Check(syslog.NewEntry([]byte("<11>retry 1"), nil), now)
+	if report, _ := d.Check(syslog.NewEntry([]byte("<11>retry 2"), nil), now); !report {
+		t.Error("numbers should not be ignored")
+	}
+	if report, _ := d.Check(syslog.NewEntry([]byte("<11>retry 2"), nil), now); report {
+		t.Error("identical message should be suppressed")
+	}
+}
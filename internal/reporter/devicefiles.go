@@ -0,0 +1,181 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// DefaultMaxOpen is the default number of files DeviceFiles keeps open
+const DefaultMaxOpen = 64
+
+// DeviceFiles writes each entry to a file whose path is given by a template, such
+// as logs/{{.RemoteIP}}/{{.Date}}.log, so each device can have its own log.
+//
+// Directories are created when needed. Only the most recently used files are kept
+// open: the others are closed and reopened if another entry arrives for them.
+// Field values are made safe to use in a path, so a device can't choose a name
+// such as ../../etc/passwd.
+type DeviceFiles struct {
+	mu      sync.Mutex
+	path    *template.Template
+	maxOpen int
+	files   map[string]*list.Element // Values are *openFile
+	lru     *list.List               // Most recently used at the front
+	onError func(error)
+}
+
+type openFile struct {
+	name string
+	f    *os.File
+}
+
+// NewDeviceFiles parses the path template and checks it can be executed
+func NewDeviceFiles(path string, maxOpen int, onError func(error)) (*DeviceFiles, error) {
+	t, err := template.New("path").Funcs(templateFuncs).Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid path template: %s", err.Error())
+	}
+	if maxOpen < 1 {
+		maxOpen = DefaultMaxOpen
+	}
+	if onError == nil {
+		onError = func(error) {}
+	}
+	self := &DeviceFiles{path: t, maxOpen: maxOpen, files: make(map[string]*list.Element), lru: list.New(), onError: onError}
+	sample := syslog.NewEntry([]byte("<13>1 2003-10-11T22:14:15.003Z host app 123 ID47 - sample"), nil)
+	if _, err := self.Path(sample); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+// Path returns the name of the file the entry should be written to
+func (self *DeviceFiles) Path(e *syslog.Entry) (string, error) {
+	b := strings.Builder{}
+	if err := self.path.Execute(&b, pathFields(e)); err != nil {
+		return "", fmt.Errorf("Invalid path template: %s", err.Error())
+	}
+	path := b.String()
+	if path == "" || os.IsPathSeparator(path[len(path)-1]) {
+		return "", fmt.Errorf("Path template gives a directory (%s) rather than a file", path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// WriteEntry writes a formatted line to the file for the entry
+func (self *DeviceFiles) WriteEntry(e *syslog.Entry, line string) {
+	name, err := self.Path(e)
+	if err != nil {
+		self.onError(err)
+		return
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	f, err := self.open(name)
+	if err != nil {
+		self.onError(err)
+		return
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		self.onError(err)
+	}
+}
+
+// open returns the open file with the given name, opening it (and closing the least
+// recently used file) if necessary
+func (self *DeviceFiles) open(name string) (*os.File, error) {
+	if el, ok := self.files[name]; ok {
+		self.lru.MoveToFront(el)
+		return el.Value.(*openFile).f, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for self.lru.Len() >= self.maxOpen {
+		self.closeFile(self.lru.Back())
+	}
+	self.files[name] = self.lru.PushFront(&openFile{name: name, f: f})
+	return f, nil
+}
+
+func (self *DeviceFiles) closeFile(el *list.Element) {
+	of := self.lru.Remove(el).(*openFile)
+	delete(self.files, of.name)
+	if err := of.f.Close(); err != nil {
+		self.onError(err)
+	}
+}
+
+// OpenFiles returns the number of files which are open
+func (self *DeviceFiles) OpenFiles() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.lru.Len()
+}
+
+// Close closes all the open files. They will be reopened by the next entry written
+// to them, so Close is also used after the files have been rotated by another program.
+func (self *DeviceFiles) Close() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for self.lru.Len() > 0 {
+		self.closeFile(self.lru.Back())
+	}
+	return nil
+}
+
+// pathFields returns the fields of an entry with the strings made safe to use as
+// file or directory names
+func pathFields(e *syslog.Entry) *Fields {
+	f := NewFields(e)
+	for _, s := range []*string{&f.RemoteIP, &f.RemoteName, &f.Transport, &f.Identity, &f.Alias, &f.Hostname, &f.AppName,
+		&f.ProcID, &f.MsgID, &f.Text, &f.Message, &f.Line} {
+		*s = safeName(*s)
+	}
+	tags := make([]string, len(f.Tags))
+	for i, tag := range f.Tags {
+		tags[i] = safeName(tag)
+	}
+	f.Tags = tags
+	return f
+}
+
+// safeName replaces characters which aren't allowed in file names, or which could
+// change the directory, with underscores
+func safeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "." || s == ".." {
+		return strings.Repeat("_", len(s))
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/m-z-b/syslogqd/internal/reporter"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func TestDeviceFiles(t *testing.T) {
+	dir := t.TempDir()
+	var errs []error
+	files, err := reporter.NewDeviceFiles(dir+"/{{.RemoteIP}}/{{.Hostname}}.log", 2, func(err error) { errs = append(errs, err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.Close()
+
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1"} {
+		addr, _ := net.ResolveUDPAddr("udp", ip+":514")
+		e := syslog.NewEntry([]byte("<13>1 2003-10-11T22:14:15.003Z host app - - - hello"), addr)
+		files.WriteEntry(e, "line")
+		if i >= 1 && files.OpenFiles() != 2 {
+			t.Errorf("%d files open, wanted 2", files.OpenFiles())
+		}
+	}
+	// A hostname can't escape the directory
+	addr, _ := net.ResolveUDPAddr("udp", "10.0.0.1:514")
+	files.WriteEntry(syslog.NewEntry([]byte("<13>1 2003-10-11T22:14:15.003Z .. app - - - hello"), addr), "line")
+	files.WriteEntry(syslog.NewEntry([]byte("<13>1 2003-10-11T22:14:15.003Z a/b app - - - hello"), addr), "line")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	for name, wanted := range map[string]string{
+		"10.0.0.1/host.log": "line\nline\n",
+		"10.0.0.2/host.log": "line\n",
+		"10.0.0.3/host.log": "line\n",
+		"10.0.0.1/__.log":   "line\n",
+		"10.0.0.1/a_b.log":  "line\n",
+	} {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != wanted {
+			t.Errorf("%s contains %q, %v: wanted %q", name, b, err, wanted)
+		}
+	}
+}
+
+func TestDeviceFilesTemplate(t *testing.T) {
+	if _, err := reporter.NewDeviceFiles("logs/{{.Nonsense}}.log", 1, nil); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+	if _, err := reporter.NewDeviceFiles("logs/{{.RemoteIP}}/", 1, nil); err == nil {
+		t.Error("expected an error for a directory")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// A Formatter converts a syslog entry to a line of output (without a trailing newline)
+type Formatter interface {
+	Format(e *syslog.Entry) string
+}
+
+// Names of the formats accepted by NewFormatter
+var formatNames = []string{"text", "json"}
+
+// NewFormatter returns the formatter with the given name
+func NewFormatter(name string) (Formatter, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return TextFormat{}, nil
+	case "json":
+		return JSONFormat{}, nil
+	}
+	return nil, fmt.Errorf("Unknown format \"%s\" (should be one of %s)", name, strings.Join(formatNames, ", "))
+}
+
+// TextFormat is the human readable format produced by syslog.Entry.String()
+type TextFormat struct{}
+
+func (TextFormat) Format(e *syslog.Entry) string {
+	return e.String()
+}
+
+// JSONFormat writes each entry as a JSON object on a single line (JSON Lines)
+//
+// Fields which were not present in the message are omitted
+type JSONFormat struct{}
+
+// jsonEntry defines the field names and order of a JSON entry
+type jsonEntry struct {
+	Time           string                       `json:"time"`
+	Received       string                       `json:"received"`
+	RemoteIP       string                       `json:"remote_ip,omitempty"`
+	RemotePort     int                          `json:"remote_port,omitempty"`
+	RemoteName     string                       `json:"remote_name,omitempty"`
+	Transport      string                       `json:"transport,omitempty"`
+	Identity       string                       `json:"identity,omitempty"`
+	Alias          string                       `json:"alias,omitempty"`
+	Tags           []string                     `json:"tags,omitempty"`
+	PeerPID        *int                         `json:"peer_pid,omitempty"`
+	PeerUID        *int                         `json:"peer_uid,omitempty"`
+	PeerGID        *int                         `json:"peer_gid,omitempty"`
+	Severity       string                       `json:"severity,omitempty"`
+	Facility       string                       `json:"facility,omitempty"`
+	Version        int                          `json:"version,omitempty"`
+	Hostname       string                       `json:"hostname,omitempty"`
+	AppName        string                       `json:"app_name,omitempty"`
+	ProcID         string                       `json:"procid,omitempty"`
+	MsgID          string                       `json:"msgid,omitempty"`
+	StructuredData map[string]map[string]string `json:"structured_data,omitempty"`
+	Truncated      bool                         `json:"truncated,omitempty"`
+	Message        string                       `json:"message"`
+}
+
+func (JSONFormat) Format(e *syslog.Entry) string {
+	j := jsonEntry{
+		Time:       e.Time().Format(time.RFC3339Nano),
+		Received:   e.Received().Format(time.RFC3339Nano),
+		RemoteIP:   e.RemoteIP(),
+		RemotePort: e.RemotePort(),
+		RemoteName: e.RemoteName(),
+		Transport:  e.Transport(),
+		Identity:   e.Identity(),
+		Alias:      e.Alias(),
+		Tags:       e.Tags(),
+		Version:    e.Version(),
+		Hostname:   e.Hostname(),
+		AppName:    e.AppName(),
+		ProcID:     e.ProcID(),
+		MsgID:      e.MsgID(),
+		Truncated:  e.Truncated(),
+		Message:    e.Text(),
+	}
+	if pid, uid, gid, ok := e.Credentials(); ok {
+		j.PeerPID, j.PeerUID, j.PeerGID = &pid, &uid, &gid
+	}
+	if e.HasSeverity() {
+		j.Severity = e.Severity().String()
+		j.Facility = e.Facility().String()
+	}
+	if sd := e.StructuredData(); sd != nil {
+		j.StructuredData = sd.Map()
+	}
+	b := strings.Builder{}
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)              // Keep <PRI> etc. readable
+	if err := enc.Encode(j); err != nil { // Can't happen: all the fields can be marshalled
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
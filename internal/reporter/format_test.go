@@ -0,0 +1,73 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter_test
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/m-z-b/syslogqd/internal/reporter"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func TestJSONFormat(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	raw := []byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3"] <An> event`)
+	f, err := reporter.NewFormatter("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := f.Format(syslog.NewEntry(raw, addr))
+	if strings.Contains(line, "\n") {
+		t.Errorf("JSON output is not a single line: %q", line)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(line), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %s", line, err)
+	}
+	for name, wanted := range map[string]any{
+		"time":        "2003-10-11T22:14:15.003Z",
+		"remote_ip":   "192.168.1.99",
+		"remote_port": 5000.0,
+		"transport":   "udp",
+		"severity":    "notice",
+		"facility":    "local4",
+		"hostname":    "mymachine.example.com",
+		"app_name":    "evntslog",
+		"msgid":       "ID47",
+		"message":     "<An> event",
+	} {
+		if got[name] != wanted {
+			t.Errorf("%s: got %v, wanted %v", name, got[name], wanted)
+		}
+	}
+	if _, ok := got["procid"]; ok {
+		t.Error("NILVALUE procid should be omitted")
+	}
+	if sd, ok := got["structured_data"].(map[string]any); !ok || sd["exampleSDID@32473"].(map[string]any)["iut"] != "3" {
+		t.Errorf("structured data not included: %v", got["structured_data"])
+	}
+}
+
+func TestNewFormatter(t *testing.T) {
+	if _, err := reporter.NewFormatter("xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if f, err := reporter.NewFormatter("TEXT"); err != nil || f.Format(syslog.NewEntry([]byte("hi"), nil)) == "" {
+		t.Error("text format not available")
+	}
+}
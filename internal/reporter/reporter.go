@@ -16,55 +16,229 @@ package reporter
 
 import (
 	"fmt"
-	"os"
+	"io"
 	"regexp"
+	"sync/atomic"
+	"time"
 
+	"github.com/m-z-b/syslogqd/internal/filter"
 	"github.com/m-z-b/syslogqd/internal/severity"
 	"github.com/m-z-b/syslogqd/internal/syslog"
 )
 
 // A Reporter repeatedly receives a syslog.Entry and writes it to a set of output streams
 //
-//    newswire := make( syslog.Channel, 10 )
-//    ...
-//    r := NewReporter()
-//    r.AddOutput(os.StdOut)
-//    go r.Report(newswire, Severity.Default() )
-//
+//	newswire := syslog.NewQueue(1000, syslog.Block)
+//	...
+//	r := NewReporter()
+//	r.AddOutput(os.StdOut, TextFormat{})
+//	go r.Report(newswire.Entries(), Severity.Default() )
 type Reporter struct {
-	files     []*os.File
-	mustMatch *regexp.Regexp
+	outputs      []output
+	mustMatch    *regexp.Regexp
+	mustIdentify *regexp.Regexp // null or the sender's authenticated identity must match this
+	filter       *filter.Filter // null or entries must pass this filter
+	patterns     *filter.Patterns
+	sources      *filter.SourceRules // Minimum severities and filters for particular sources
+	written      atomic.Uint64       // Entries written to the outputs
+	filtered     atomic.Uint64       // Entries which did not pass the filters
+	dedup        *Deduplicator       // nil unless repeated messages are collapsed
+	suppressed   atomic.Uint64       // Entries not written because they were repeats
+	namers       []Namer             // Give names to senders before entries are filtered
+	stop         chan struct{}       // Closed to stop Report
+	stopped      chan struct{}       // Closed when Report has stopped
+}
+
+// An output stream and the format used to write to it
+type output struct {
+	w       io.Writer
+	entries EntryWriter // Used instead of w if not nil
+	format  Formatter   // nil if the EntryWriter formats entries itself
+	colors  *Colorizer  // nil for no colour
+}
+
+// An EntryWriter is an output which needs the entry as well as the formatted line,
+// for example to choose where the line is written
+type EntryWriter interface {
+	WriteEntry(e *syslog.Entry, line string)
+}
+
+// A Namer gives names to the senders of entries, e.g. using Entry.SetAlias
+type Namer interface {
+	Apply(e *syslog.Entry)
 }
 
 // NewReporter constructs a new Reporter instance
 func NewReporter(mustMatch *regexp.Regexp) (r *Reporter) {
-	r = &Reporter{mustMatch: mustMatch}
-	r.files = make([]*os.File, 0, 5)
+	r = &Reporter{mustMatch: mustMatch, stop: make(chan struct{}), stopped: make(chan struct{})}
+	r.outputs = make([]output, 0, 5)
 	return
 }
 
-// AddOutput adds a stream the output should be sent to
-func (self *Reporter) AddOutput(file *os.File) *Reporter {
-	self.files = append(self.files, file)
+// AddOutput adds a stream the output should be sent to, and the format to use
+func (self *Reporter) AddOutput(w io.Writer, format Formatter) *Reporter {
+	self.outputs = append(self.outputs, output{w: w, format: format})
 	return self
 }
 
+// AddColoredOutput adds a stream (normally a terminal) the output should be sent to,
+// with colours added by the colorizer
+func (self *Reporter) AddColoredOutput(w io.Writer, format Formatter, colors *Colorizer) *Reporter {
+	self.outputs = append(self.outputs, output{w: w, format: format, colors: colors})
+	return self
+}
+
+// AddEntryOutput adds an output which is given each entry along with the line formatted
+// using format. If format is nil, the line is empty.
+func (self *Reporter) AddEntryOutput(w EntryWriter, format Formatter) *Reporter {
+	self.outputs = append(self.outputs, output{entries: w, format: format})
+	return self
+}
+
+// RequireIdentity only reports entries whose sender has an authenticated identity
+// matching the regex
+func (self *Reporter) RequireIdentity(regex *regexp.Regexp) *Reporter {
+	self.mustIdentify = regex
+	return self
+}
+
+// SetFilter only reports entries which pass the filter (as well as the minimum
+// severity and regex)
+func (self *Reporter) SetFilter(f *filter.Filter) *Reporter {
+	self.filter = f
+	return self
+}
+
+// SetPatterns only reports entries which pass the include and exclude patterns
+func (self *Reporter) SetPatterns(p *filter.Patterns) *Reporter {
+	self.patterns = p
+	return self
+}
+
+// SetSourceRules uses the rule for an entry's source, if there is one, instead of
+// the minimum severity passed to Report
+func (self *Reporter) SetSourceRules(rules *filter.SourceRules) *Reporter {
+	self.sources = rules
+	return self
+}
+
+// Deduplicate collapses repeated messages using d, which may be nil
+func (self *Reporter) Deduplicate(d *Deduplicator) *Reporter {
+	self.dedup = d
+	return self
+}
+
+// AddNamer adds a Namer which names the senders of entries before they are filtered
+func (self *Reporter) AddNamer(n Namer) *Reporter {
+	self.namers = append(self.namers, n)
+	return self
+}
+
+// Patterns returns the include and exclude patterns, or nil if there are none
+func (self *Reporter) Patterns() *filter.Patterns {
+	return self.patterns
+}
+
+// Written is the number of entries written to the outputs
+func (self *Reporter) Written() uint64 {
+	return self.written.Load()
+}
+
+// Filtered is the number of entries which were not written because of their
+// severity or because they did not match
+func (self *Reporter) Filtered() uint64 {
+	return self.filtered.Load()
+}
+
+// Suppressed is the number of entries which were not written because they repeated
+// an earlier message
+func (self *Reporter) Suppressed() uint64 {
+	return self.suppressed.Load()
+}
+
 // Write a syslog entry to all the file streams
 func (self *Reporter) reportEntry(e *syslog.Entry) {
-	for _, f := range self.files {
-		fmt.Fprintln(f, e)
+	for _, o := range self.outputs {
+		line := ""
+		if o.format != nil {
+			line = o.format.Format(e)
+		}
+		if o.colors != nil {
+			line = o.colors.Colorize(e, line)
+		}
+		if o.entries != nil {
+			o.entries.WriteEntry(e, line)
+		} else {
+			fmt.Fprintln(o.w, line)
+		}
 	}
+	self.written.Add(1)
 }
 
 // Report gets a new SyslogEntry from the newswire channel and reports it to all outputs
-// if it has at least the given severity
+// if it has at least the given severity (or the severity set by the rule for its
+// source) and passes the filters, until Stop is called
 func (self *Reporter) Report(newswire syslog.Channel, minSeverity severity.Severity) {
+	defer close(self.stopped)
+	var tick <-chan time.Time // Time to report the repeat counts of any duplicates
+	if self.dedup != nil {
+		ticker := time.NewTicker(min(max(self.dedup.Window()/10, 10*time.Millisecond), time.Second))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
 	for {
-		var e = <-newswire
-		if !e.HasSeverity() || e.Severity().AsOrMoreSevereThan(minSeverity) {
-			if e.Matches(self.mustMatch) { // e.Matches handles nil as match any
-				self.reportEntry(e)
+		select {
+		case e := <-newswire:
+			self.handle(e, minSeverity)
+		case now := <-tick:
+			for _, summary := range self.dedup.Expired(now, false) {
+				self.reportEntry(summary)
+			}
+		case <-self.stop:
+			// Report the entries already queued, and then the repeat counts which
+			// haven't been reported yet
+			for len(newswire) > 0 {
+				self.handle(<-newswire, minSeverity)
 			}
+			if self.dedup != nil {
+				for _, summary := range self.dedup.Expired(time.Now(), true) {
+					self.reportEntry(summary)
+				}
+			}
+			return
 		}
 	}
 }
+
+// handle names the sender of an entry, and reports it if it passes the filters and
+// isn't a repeat
+func (self *Reporter) handle(e *syslog.Entry, minSeverity severity.Severity) {
+	for _, n := range self.namers {
+		n.Apply(e)
+	}
+	switch {
+	case !self.sources.Matches(e, minSeverity) ||
+		!e.Matches(self.mustMatch) || !e.IdentityMatches(self.mustIdentify) || !self.filter.Matches(e) ||
+		!self.patterns.Matches(e): // all handle nil as match any
+		self.filtered.Add(1)
+	case self.dedup != nil:
+		report, summary := self.dedup.Check(e, time.Now())
+		if summary != nil {
+			self.reportEntry(summary)
+		}
+		if report {
+			self.reportEntry(e)
+		} else {
+			self.suppressed.Add(1)
+		}
+	default:
+		self.reportEntry(e)
+	}
+}
+
+// Stop stops Report, which has been started with go, after it has reported the
+// entries waiting in its channel and the counts of any repeated messages
+func (self *Reporter) Stop() {
+	close(self.stop)
+	<-self.stopped
+}
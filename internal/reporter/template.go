@@ -0,0 +1,168 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/m-z-b/syslogqd/internal/facility"
+	"github.com/m-z-b/syslogqd/internal/severity"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// Fields are the values of a syslog entry available to templates
+type Fields struct {
+	Time           time.Time // Time of the entry in UTC
+	Received       time.Time // Time the entry was received in UTC
+	Date           string    // Date of the entry as 2006-01-02
+	RemoteIP       string
+	RemotePort     int
+	RemoteName     string // Name of RemoteIP found by -resolve, or ""
+	Transport      string
+	Identity       string // Authenticated identity of the sender
+	Alias          string // Name of the sender from the alias file, or ""
+	Tags           []string
+	HasSeverity    bool // false if the message had no PRI: Severity and Facility are then defaults
+	Severity       severity.Severity
+	Facility       facility.Facility
+	Version        int
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData syslog.StructuredData
+	Text           string // The message text alone
+	Message        string // Header fields and structured data followed by the text
+	Truncated      bool
+	Line           string // The entry in the default text format
+}
+
+// NewFields extracts the fields of an entry
+func NewFields(e *syslog.Entry) *Fields {
+	f := &Fields{
+		Time:           e.Time(),
+		Received:       e.Received(),
+		Date:           e.Time().Format("2006-01-02"),
+		RemoteIP:       e.RemoteIP(),
+		RemotePort:     e.RemotePort(),
+		RemoteName:     e.RemoteName(),
+		Transport:      e.Transport(),
+		Identity:       e.Identity(),
+		Alias:          e.Alias(),
+		Tags:           e.Tags(),
+		HasSeverity:    e.HasSeverity(),
+		Severity:       severity.Default(),
+		Facility:       facility.Default(),
+		Version:        e.Version(),
+		Hostname:       e.Hostname(),
+		AppName:        e.AppName(),
+		ProcID:         e.ProcID(),
+		MsgID:          e.MsgID(),
+		StructuredData: e.StructuredData(),
+		Text:           e.Text(),
+		Message:        e.Message(),
+		Truncated:      e.Truncated(),
+		Line:           e.String(),
+	}
+	if e.HasSeverity() {
+		f.Severity = e.Severity()
+		f.Facility = e.Facility()
+	}
+	return f
+}
+
+// templateFuncs are the helper functions available to templates
+var templateFuncs = template.FuncMap{
+	// Time zones and formatting: {{.Time | local | timefmt "15:04:05.000"}}
+	"utc":     func(t time.Time) time.Time { return t.UTC() },
+	"local":   func(t time.Time) time.Time { return t.Local() },
+	"timefmt": func(layout string, t time.Time) string { return t.Format(layout) },
+	"rfc3339": func(t time.Time) string { return t.Format(time.RFC3339) },
+	// Fixed width columns: {{pad 15 .RemoteIP}} {{lpad 5 .ProcID}} {{trunc 20 .AppName}}
+	"pad":   func(n int, s string) string { return pad(s, n, false) },
+	"lpad":  func(n int, s string) string { return pad(s, n, true) },
+	"trunc": truncate,
+	// Severity names: {{abbrev .Severity}} gives "crit", {{upper (abbrev .Severity)}} "CRIT"
+	"abbrev": func(s severity.Severity) string { return s.Abbreviation() },
+	"upper":  strings.ToUpper,
+	// Lists: {{join "," .Tags}}
+	"join":  func(sep string, s []string) string { return strings.Join(s, sep) },
+	"lower": strings.ToLower,
+	// Missing values: {{default "-" .Hostname}}
+	"default": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+}
+
+// TemplateFormat formats entries using a text/template over their Fields, e.g.
+//
+//	{{.Time | local | timefmt "15:04:05"}} {{pad 15 .RemoteIP}} {{abbrev .Severity}} {{.Message}}
+type TemplateFormat struct {
+	t *template.Template
+}
+
+// NewTemplateFormat parses a template
+func NewTemplateFormat(text string) (*TemplateFormat, error) {
+	t, err := template.New("format").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template: %s", err.Error())
+	}
+	return &TemplateFormat{t: t}, nil
+}
+
+// Check executes the template on a sample entry to find errors, such as unknown fields,
+// which can't be detected when the template is parsed
+func (self *TemplateFormat) Check() error {
+	sample := syslog.NewEntry([]byte("<13>1 2003-10-11T22:14:15.003Z host app 123 ID47 - sample"), nil)
+	if err := self.t.Execute(&strings.Builder{}, NewFields(sample)); err != nil {
+		return fmt.Errorf("Invalid template: %s", err.Error())
+	}
+	return nil
+}
+
+func (self *TemplateFormat) Format(e *syslog.Entry) string {
+	b := strings.Builder{}
+	if err := self.t.Execute(&b, NewFields(e)); err != nil {
+		return fmt.Sprintf("template error: %s: %s", err.Error(), e)
+	}
+	return b.String()
+}
+
+// pad adds spaces to s to make it at least n characters wide
+func pad(s string, n int, left bool) string {
+	spaces := n - utf8.RuneCountInString(s)
+	if spaces <= 0 {
+		return s
+	}
+	if left {
+		return strings.Repeat(" ", spaces) + s
+	}
+	return s + strings.Repeat(" ", spaces)
+}
+
+// truncate shortens s to at most n characters
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporter_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/m-z-b/syslogqd/internal/reporter"
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+var templateExamples = []struct {
+	template string
+	wanted   string
+}{
+	{`{{.Time | utc | timefmt "15:04:05.000"}} {{pad 14 .RemoteIP}}|{{lpad 4 .ProcID}}|`, "22:14:15.003 192.168.1.99  |  42|"},
+	{`{{upper (abbrev .Severity)}} {{.Facility}} {{trunc 5 .Hostname}} {{default "-" .MsgID}}`, "CRIT auth mymac -"},
+	{`{{.Date}} {{.Text}}`, "2003-10-11 'su root' failed"},
+	{`{{.Message}}`, "mymachine.example.com su[42]: 'su root' failed"},
+	{`{{default .RemoteIP .Alias}} {{join "," .Tags}}`, "server security,linux"},
+}
+
+func TestTemplateFormat(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	e := syslog.NewEntry([]byte("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su 42 - - 'su root' failed"), addr)
+	e.SetAlias("server", []string{"security", "linux"})
+	for _, ex := range templateExamples {
+		f, err := reporter.NewTemplateFormat(ex.template)
+		if err != nil {
+			t.Errorf("%q: %s", ex.template, err)
+			continue
+		}
+		if got := f.Format(e); got != ex.wanted {
+			t.Errorf("%q: got %q, wanted %q", ex.template, got, ex.wanted)
+		}
+	}
+}
+
+func TestBadTemplates(t *testing.T) {
+	if _, err := reporter.NewTemplateFormat("{{.Time"); err == nil {
+		t.Error("expected parse error")
+	}
+	f, err := reporter.NewTemplateFormat("{{.NoSuchField}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Check() == nil {
+		t.Error("expected error for unknown field")
+	}
+}
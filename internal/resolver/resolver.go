@@ -0,0 +1,260 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resolver finds the names of the IP addresses which send syslog entries,
+// using reverse DNS and/or a hosts file, without delaying the entries
+package resolver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// Defaults for Options
+const (
+	DefaultTTL         = time.Hour
+	DefaultNegativeTTL = 5 * time.Minute
+	DefaultTimeout     = 5 * time.Second
+	DefaultWorkers     = 4
+	DefaultMaxCached   = 10000
+)
+
+// Options control how names are found
+type Options struct {
+	DNS         bool          // Look up PTR records
+	Hosts       string        // File in /etc/hosts format which is checked before DNS ("" for none)
+	TTL         time.Duration // How long DNS names are cached
+	NegativeTTL time.Duration // How long addresses without a name are cached
+	Timeout     time.Duration // Maximum time for a DNS lookup
+	Workers     int           // Number of DNS lookups which can run at once
+	MaxCached   int           // Maximum number of addresses cached
+
+	// LookupAddr finds the names of an address (default net.DefaultResolver.LookupAddr)
+	LookupAddr func(ctx context.Context, addr string) ([]string, error)
+}
+
+// A Resolver finds the names of IP addresses
+//
+// Names in the hosts file are found immediately. DNS lookups are made in the
+// background, so the first entries from an address are shown without a name.
+// Entries are then given the cached name, which is refreshed when it expires.
+type Resolver struct {
+	options  Options
+	requests chan string // Addresses to look up
+	stop     chan struct{}
+
+	mu    sync.Mutex
+	hosts map[string]string // Address to name from the hosts file
+	cache map[string]*cached
+}
+
+type cached struct {
+	name    string // "" if the address has no name
+	expires time.Time
+	pending bool // Being looked up
+}
+
+// New creates a Resolver, reading the hosts file if there is one
+func New(options Options) (*Resolver, error) {
+	if options.TTL <= 0 {
+		options.TTL = DefaultTTL
+	}
+	if options.NegativeTTL <= 0 {
+		options.NegativeTTL = DefaultNegativeTTL
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.Workers <= 0 {
+		options.Workers = DefaultWorkers
+	}
+	if options.MaxCached <= 0 {
+		options.MaxCached = DefaultMaxCached
+	}
+	if options.LookupAddr == nil {
+		options.LookupAddr = net.DefaultResolver.LookupAddr
+	}
+	self := &Resolver{
+		options:  options,
+		requests: make(chan string, 100),
+		stop:     make(chan struct{}),
+		cache:    make(map[string]*cached),
+	}
+	if err := self.ReloadHosts(); err != nil {
+		return nil, err
+	}
+	if options.DNS {
+		for i := 0; i < options.Workers; i++ {
+			go self.lookups()
+		}
+	}
+	return self, nil
+}
+
+// ReloadHosts reads the hosts file again
+func (self *Resolver) ReloadHosts() error {
+	if self.options.Hosts == "" {
+		return nil
+	}
+	f, err := os.Open(self.options.Hosts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hosts, err := ReadHosts(f, self.options.Hosts)
+	if err != nil {
+		return err
+	}
+	self.mu.Lock()
+	self.hosts = hosts
+	self.mu.Unlock()
+	return nil
+}
+
+// ReadHosts reads a file in /etc/hosts format, returning a map from each address to
+// its first name; name is used in error messages
+func ReadHosts(r io.Reader, name string) (map[string]string, error) {
+	hosts := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		s, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if len(fields) < 2 || err != nil {
+			return nil, fmt.Errorf("%s:%d: should be ADDRESS NAME [ALIAS...]", name, line)
+		}
+		if key := addr.Unmap().String(); hosts[key] == "" {
+			hosts[key] = fields[1]
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+// Name returns the name of an IP address, or "" if it isn't known (yet)
+//
+// It never waits for a DNS lookup.
+func (self *Resolver) Name(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	ip = addr.Unmap().String()
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if name, ok := self.hosts[ip]; ok {
+		return name
+	}
+	if !self.options.DNS {
+		return ""
+	}
+	c := self.cache[ip]
+	if c == nil && !self.makeRoom(time.Now()) {
+		return "" // Every cached address is being looked up
+	}
+	if c == nil || (!c.pending && time.Now().After(c.expires)) {
+		select {
+		case self.requests <- ip:
+			if c == nil {
+				c = &cached{}
+				self.cache[ip] = c
+			}
+			c.pending = true
+		default: // Too many lookups waiting: try again with the next entry
+		}
+	}
+	if c == nil {
+		return ""
+	}
+	return c.name // The old name is used while an expired one is looked up again
+}
+
+// Apply records the name of the entry's remote IP address in the entry
+func (self *Resolver) Apply(e *syslog.Entry) {
+	if name := self.Name(e.RemoteIP()); name != "" {
+		e.SetRemoteName(name)
+	}
+}
+
+// lookups makes DNS lookups until the Resolver is closed
+func (self *Resolver) lookups() {
+	for {
+		select {
+		case <-self.stop:
+			return
+		case ip := <-self.requests:
+			ctx, cancel := context.WithTimeout(context.Background(), self.options.Timeout)
+			names, err := self.options.LookupAddr(ctx, ip)
+			cancel()
+			name, ttl := "", self.options.NegativeTTL
+			if err == nil && len(names) > 0 {
+				name, ttl = strings.TrimSuffix(names[0], "."), self.options.TTL
+			}
+			self.store(ip, name, ttl)
+		}
+	}
+}
+
+// store caches the result of a lookup
+func (self *Resolver) store(ip, name string, ttl time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	now := time.Now()
+	if self.cache[ip] == nil && !self.makeRoom(now) {
+		return
+	}
+	self.cache[ip] = &cached{name: name, expires: now.Add(ttl)}
+}
+
+// makeRoom makes sure another address can be cached, by forgetting the expired names
+// or if there aren't any the name which expires first. It returns false if every
+// cached address is being looked up.
+func (self *Resolver) makeRoom(now time.Time) bool {
+	if len(self.cache) < self.options.MaxCached {
+		return true
+	}
+	oldest := ""
+	for key, c := range self.cache {
+		switch {
+		case c.pending:
+		case now.After(c.expires):
+			delete(self.cache, key)
+		case oldest == "" || c.expires.Before(self.cache[oldest].expires):
+			oldest = key
+		}
+	}
+	if len(self.cache) >= self.options.MaxCached && oldest != "" {
+		delete(self.cache, oldest)
+	}
+	return len(self.cache) < self.options.MaxCached
+}
+
+// Close stops the DNS lookups
+func (self *Resolver) Close() error {
+	close(self.stop)
+	return nil
+}
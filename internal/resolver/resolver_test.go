@@ -0,0 +1,179 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-z-b/syslogqd/internal/resolver"
+)
+
+// fakeDNS answers lookups from a map, counting them
+type fakeDNS struct {
+	mu      sync.Mutex
+	names   map[string]string
+	lookups map[string]int
+	release chan struct{} // If not nil, lookups wait until this is closed
+}
+
+func (self *fakeDNS) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if self.release != nil {
+		<-self.release
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.lookups[addr]++
+	if name, ok := self.names[addr]; ok {
+		return []string{name + "."}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (self *fakeDNS) count(addr string) int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.lookups[addr]
+}
+
+// eventually waits for the resolver to find the wanted name
+func eventually(t *testing.T, r *resolver.Resolver, ip, wanted string) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if r.Name(ip) == wanted {
+			return
+		}
+	}
+	t.Errorf("%s: got %q, wanted %q", ip, r.Name(ip), wanted)
+}
+
+func TestResolverDNS(t *testing.T) {
+	dns := &fakeDNS{names: map[string]string{"10.0.0.1": "router.example"}, lookups: map[string]int{}}
+	r, err := resolver.New(resolver.Options{DNS: true, TTL: time.Hour, NegativeTTL: time.Hour, LookupAddr: dns.LookupAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	eventually(t, r, "10.0.0.1", "router.example")
+	eventually(t, r, "::ffff:10.0.0.1", "router.example")
+	r.Name("10.0.0.2")
+	time.Sleep(20 * time.Millisecond)
+	eventually(t, r, "10.0.0.2", "")
+	if dns.count("10.0.0.1") != 1 || dns.count("10.0.0.2") != 1 {
+		t.Errorf("names were not cached: %v", dns.lookups)
+	}
+	if r.Name("not an address") != "" || r.Name("") != "" {
+		t.Error("expected no name for an invalid address")
+	}
+}
+
+func TestResolverExpiry(t *testing.T) {
+	dns := &fakeDNS{names: map[string]string{"10.0.0.1": "old.example"}, lookups: map[string]int{}}
+	r, err := resolver.New(resolver.Options{DNS: true, TTL: 10 * time.Millisecond, LookupAddr: dns.LookupAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	eventually(t, r, "10.0.0.1", "old.example")
+
+	dns.mu.Lock()
+	dns.names["10.0.0.1"] = "new.example"
+	dns.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	eventually(t, r, "10.0.0.1", "new.example")
+}
+
+func TestResolverDoesNotWait(t *testing.T) {
+	dns := &fakeDNS{names: map[string]string{"10.0.0.1": "slow.example"}, lookups: map[string]int{}, release: make(chan struct{})}
+	r, err := resolver.New(resolver.Options{DNS: true, Workers: 1, LookupAddr: dns.LookupAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		if r.Name("10.0.0.1") != "" {
+			t.Fatal("name found before the lookup finished")
+		}
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Name waited for the lookup")
+	}
+	close(dns.release)
+	eventually(t, r, "10.0.0.1", "slow.example")
+	if dns.count("10.0.0.1") != 1 {
+		t.Errorf("got %d lookups", dns.count("10.0.0.1"))
+	}
+}
+
+func TestResolverMaxCached(t *testing.T) {
+	dns := &fakeDNS{names: map[string]string{"10.0.0.1": "a.example", "10.0.0.2": "b.example", "10.0.0.3": "c.example"}, lookups: map[string]int{}}
+	r, err := resolver.New(resolver.Options{DNS: true, MaxCached: 2, LookupAddr: dns.LookupAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	eventually(t, r, "10.0.0.1", "a.example")
+	eventually(t, r, "10.0.0.2", "b.example")
+
+	// 10.0.0.1 expires first, so it is forgotten to make room for 10.0.0.3
+	eventually(t, r, "10.0.0.3", "c.example")
+	if r.Name("10.0.0.2") != "b.example" {
+		t.Error("10.0.0.2 was forgotten")
+	}
+	if r.Name("10.0.0.1") != "" {
+		t.Error("10.0.0.1 was not forgotten")
+	}
+	eventually(t, r, "10.0.0.1", "a.example")
+	if dns.count("10.0.0.1") != 2 || dns.count("10.0.0.2") != 1 {
+		t.Errorf("got lookups %v", dns.lookups)
+	}
+}
+
+func TestResolverHosts(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(name, []byte("# Bench devices\n192.168.1.49 kitchen-relay relay\n::1 localhost # comment\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := resolver.New(resolver.Options{Hosts: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for ip, wanted := range map[string]string{"192.168.1.49": "kitchen-relay", "::ffff:192.168.1.49": "kitchen-relay", "::1": "localhost", "10.0.0.1": ""} {
+		if got := r.Name(ip); got != wanted {
+			t.Errorf("%s: got %q, wanted %q", ip, got, wanted)
+		}
+	}
+
+	if err := os.WriteFile(name, []byte("192.168.1.49 pantry-relay\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReloadHosts(); err != nil || r.Name("192.168.1.49") != "pantry-relay" {
+		t.Errorf("hosts file not reloaded: %v", err)
+	}
+
+	_, err = resolver.ReadHosts(strings.NewReader("10.0.0.1 ok\nnonsense here\n"), "hosts")
+	if err == nil || !strings.HasPrefix(err.Error(), "hosts:2:") {
+		t.Errorf("expected an error with a line number, got %v", err)
+	}
+}
@@ -26,6 +26,9 @@ type Severity uint8
 // names are taken from RFC 5424 Table 2 - we use debug(7) for entries with no defined severity
 var names []string = []string{"emergency", "alert", "critical", "error", "warning", "notice", "info", "debug"}
 
+// abbreviations are the keywords used in syslog.conf
+var abbreviations []string = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
 func (s Severity) String() string {
 	if int(s) < len(names) {
 		return names[s]
@@ -34,6 +37,15 @@ func (s Severity) String() string {
 	}
 }
 
+// Abbreviation returns the short name used in syslog.conf, e.g. "crit" for critical
+func (s Severity) Abbreviation() string {
+	if int(s) < len(abbreviations) {
+		return abbreviations[s]
+	} else {
+		return s.String()
+	}
+}
+
 // AsOrMoreSevereThan(other) returns T if self is as or more severe than other
 func (s Severity) AsOrMoreSevereThan(other Severity) bool {
 	// 0 is more severe than 1
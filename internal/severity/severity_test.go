@@ -91,3 +91,14 @@ func TestAsOrMoreSevereThan(t *testing.T) {
 		}
 	}
 }
+
+func TestAbbreviation(t *testing.T) {
+	for _, ex := range []struct {
+		value uint8
+		abbr  string
+	}{{0, "emerg"}, {2, "crit"}, {3, "err"}, {7, "debug"}, {8, "severity(8)!"}} {
+		if got := severity.Severity(ex.value).Abbreviation(); got != ex.abbr {
+			t.Errorf("got %q, wanted %q", got, ex.abbr)
+		}
+	}
+}
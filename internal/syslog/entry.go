@@ -32,32 +32,64 @@ var (
 	rMultiWhiteSpace = regexp.MustCompile(`\s+`)
 )
 
+// nilValue is used by RFC 5424 for header fields which have no value
+const nilValue = "-"
+
+// bom is the UTF-8 byte order mark which may start an RFC 5424 MSG
+const bom = "\xEF\xBB\xBF"
+
 // A syslog entry received from a remote client
 type Entry struct {
 	text        string // The entry
+	raw         string // The message as received
 	remoteIP    string
+	remotePort  int
+	transport   string    // udp, tcp, tls, unix or unixgram
+	received    time.Time // Time the entry was received, in UTC
+	identity    string    // Authenticated identity of the sender (e.g. from a TLS client certificate)
+	truncated   bool      // Was part of the message discarded?
+	hasCred     bool      // Were the credentials of a local sender supplied?
+	alias       string    // Name given to the sender in an alias file, or ""
+	tags        []string  // Tags given to the sender in an alias file
+	remoteName  string    // Name of the remote IP address found by a resolver, or ""
+	pid         int
+	uid         int
+	gid         int
 	time        time.Time         // Time in UTC - either received time or time parsed from string
 	severity    severity.Severity // 0..7
 	facility    facility.Facility // 0..23 = kernel..local7
 	hasSeverity bool              // Was severity/priority supplied?
+
+	// RFC 5424 header fields - "" if not supplied or NILVALUE
+	version  int // 0 if not an RFC 5424 message
+	hostname string
+	appName  string
+	procID   string
+	msgID    string
+	sd       StructuredData // nil if none supplied
 }
 
 // Create a syslog entry from a set of bytes
 func NewEntry(bytes []byte, remoteAddress net.Addr) *Entry {
-	r := &Entry{time: time.Now().UTC(), severity: severity.Default(), facility: facility.Default()}
+	r := &Entry{raw: string(bytes), time: time.Now().UTC(), severity: severity.Default(), facility: facility.Default()}
+	r.received = r.time
 	switch addr := remoteAddress.(type) {
 	case *net.UDPAddr:
-		r.remoteIP = addr.IP.String()
+		r.remoteIP, r.remotePort, r.transport = addr.IP.String(), addr.Port, "udp"
 	case *net.TCPAddr:
-		r.remoteIP = addr.IP.String()
+		r.remoteIP, r.remotePort, r.transport = addr.IP.String(), addr.Port, "tcp"
+	case *net.UnixAddr:
+		if addr != nil { // nil if the sender's socket isn't bound, as for logger
+			r.transport = addr.Net
+		}
 	}
 
 	// If this is a properly formatted string, it starts with
 	// <priority>timestamp
 	priority := rPriority.Find(bytes)
 	if priority != nil {
-		// priority is a slice <123> - we ignore the facility part
-		i, err := strconv.ParseUint(string(priority[1:len(priority)-1]), 10, 8)
+		// priority is a slice <123> possibly preceded by an octet count
+		i, err := strconv.ParseUint(string(priority[strings.IndexByte(string(priority), '<')+1:len(priority)-1]), 10, 8)
 		if err == nil {
 			s, f := uint8(i%8), uint8(i/8)
 			if f <= 23 { // Legal facility index
@@ -68,20 +100,139 @@ func NewEntry(bytes []byte, remoteAddress net.Addr) *Entry {
 			}
 		}
 	}
-	// If available, the timestamp is extracted from the bytes and used as the
-	// time of the entry
+	if !r.parseRFC5424(string(bytes)) && !r.parseRFC3164(string(bytes)) {
+		r.parseTolerant(bytes)
+	}
+	return r
+}
+
+// NewSyntheticEntry creates an entry generated by syslogqd itself, such as a summary
+// of repeated messages. It appears to come from the same source as like, with the
+// same severity, facility and header fields, but has the given text and the current
+// time. The structured data is not copied, as it describes like's message.
+func NewSyntheticEntry(like *Entry, text string) *Entry {
+	r := &Entry{
+		raw:         text,
+		remoteIP:    like.remoteIP,
+		remotePort:  like.remotePort,
+		transport:   like.transport,
+		time:        time.Now().UTC(),
+		severity:    like.severity,
+		facility:    like.facility,
+		hasSeverity: like.hasSeverity,
+		version:     like.version,
+		hostname:    like.hostname,
+		appName:     like.appName,
+		procID:      like.procID,
+		msgID:       like.msgID,
+		identity:    like.identity,
+		alias:       like.alias,
+		tags:        like.tags,
+		remoteName:  like.remoteName,
+	}
+	r.received = r.time
+	r.setText(text)
+	return r
+}
+
+// parseRFC5424 parses the remainder of a message following the PRI as
+//
+//	VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+//
+// If s is not a valid RFC 5424 message the entry is left unchanged and false is returned
+func (self *Entry) parseRFC5424(s string) bool {
+	if !self.hasSeverity {
+		return false // PRI is mandatory
+	}
+	fields := strings.SplitN(s, " ", 7)
+	if len(fields) < 7 {
+		return false
+	}
+	version, err := strconv.Atoi(fields[0])
+	if err != nil || version < 1 || version > 999 || fields[0][0] == '0' {
+		return false
+	}
+	t := self.time
+	if fields[1] != nilValue {
+		if t, err = time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+			return false
+		}
+	}
+	for i, maxLen := range []int{255, 48, 128, 32} { // HOSTNAME, APP-NAME, PROCID, MSGID
+		if !isHeaderField(fields[2+i], maxLen) {
+			return false
+		}
+	}
+	var sd StructuredData
+	rest := fields[6]
+	switch {
+	case rest == nilValue:
+		rest = ""
+	case strings.HasPrefix(rest, nilValue+" "):
+		rest = rest[len(nilValue)+1:]
+	default:
+		var ok bool
+		if sd, rest, ok = parseStructuredData(rest); !ok {
+			return false
+		}
+		if rest != "" && rest[0] != ' ' {
+			return false
+		}
+		rest = strings.TrimPrefix(rest, " ") // So that a BOM at the start of MSG is removed
+	}
+
+	self.version = version
+	self.time = t.UTC()
+	self.hostname = headerValue(fields[2])
+	self.appName = headerValue(fields[3])
+	self.procID = headerValue(fields[4])
+	self.msgID = headerValue(fields[5])
+	self.sd = sd
+	self.setText(strings.TrimPrefix(rest, bom))
+	return true
+}
+
+// isHeaderField returns true if s is 1 to maxLen printable US-ASCII characters
+func isHeaderField(s string, maxLen int) bool {
+	if len(s) < 1 || len(s) > maxLen {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 33 || s[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+// headerValue converts the NILVALUE to an empty string
+func headerValue(s string) string {
+	if s == nilValue {
+		return ""
+	}
+	return s
+}
+
+// parseTolerant handles messages which do not follow any RFC
+//
+// If available, the timestamp is extracted from the bytes and used as the
+// time of the entry
+func (self *Entry) parseTolerant(bytes []byte) {
 	ts := rTimeStamp.FindIndex(bytes)
 	if ts != nil {
 		t, err := time.Parse(time.RFC3339Nano, string(bytes[ts[0]:ts[1]]))
 		if err == nil {
-			r.time = t.UTC()
+			self.time = t.UTC()
 			bytes = append(bytes[0:ts[0]], bytes[ts[1]:]...)
 		}
 	}
-	// Clean up the text by removing leading/trailing/multiple white space
-	r.text = strings.TrimSpace(string(bytes))
-	r.text = rMultiWhiteSpace.ReplaceAllLiteralString(r.text, " ")
-	return r
+	self.setText(string(bytes))
+}
+
+// Clean up the text by removing leading/trailing/multiple white space
+func (self *Entry) setText(s string) {
+	self.text = strings.TrimSpace(s)
+	self.text = rMultiWhiteSpace.ReplaceAllLiteralString(self.text, " ")
 }
 
 func (self *Entry) Severity() severity.Severity {
@@ -95,27 +246,247 @@ func (self *Entry) HasSeverity() bool {
 	return self.hasSeverity
 }
 
+// Facility is only available if HasSeverity() is true
+func (self *Entry) Facility() facility.Facility {
+	if !self.hasSeverity {
+		panic("syslog.Entry: asked for facility when none supplied")
+	}
+	return self.facility
+}
+
+// SetTransport records the protocol the entry was received with, if it
+// can't be worked out from the remote address (e.g. "tls")
+func (self *Entry) SetTransport(transport string) {
+	self.transport = transport
+}
+
+// Transport is the protocol the entry was received with: udp, tcp, tls, unix or unixgram
+func (self *Entry) Transport() string {
+	return self.transport
+}
+
+// SetIdentity records the authenticated identity of the sender
+//
+// This should only be called by listeners which have verified the identity
+func (self *Entry) SetIdentity(identity string) {
+	self.identity = identity
+}
+
+// Identity is the authenticated identity of the sender, or "" if the sender was not authenticated
+func (self *Entry) Identity() string {
+	return self.identity
+}
+
+// SetAlias records the name and tags given to the sender in an alias file
+func (self *Entry) SetAlias(alias string, tags []string) {
+	self.alias, self.tags = alias, tags
+}
+
+// Alias is the name given to the sender in an alias file, or "" if it doesn't have one
+func (self *Entry) Alias() string {
+	return self.alias
+}
+
+// Tags are the tags given to the sender in an alias file
+func (self *Entry) Tags() []string {
+	return self.tags
+}
+
+// SetRemoteName records the name of the remote IP address, e.g. from reverse DNS
+func (self *Entry) SetRemoteName(name string) {
+	self.remoteName = name
+}
+
+// RemoteName is the name of the remote IP address, or "" if it isn't known
+func (self *Entry) RemoteName() string {
+	return self.remoteName
+}
+
+// SetTruncated records that part of the message was discarded because it was too long
+func (self *Entry) SetTruncated() {
+	self.truncated = true
+}
+
+// Truncated returns true if part of the message was discarded
+func (self *Entry) Truncated() bool {
+	return self.truncated
+}
+
+// SetCredentials records the process, user and group ids of a local sender
+func (self *Entry) SetCredentials(pid, uid, gid int) {
+	self.pid, self.uid, self.gid = pid, uid, gid
+	self.hasCred = true
+}
+
+// Credentials returns the process, user and group ids of a local sender
+//
+// ok is false if the entry was not received from a local process
+// or the operating system did not supply them
+func (self *Entry) Credentials() (pid, uid, gid int, ok bool) {
+	return self.pid, self.uid, self.gid, self.hasCred
+}
+
+// IdentityMatches returns true if the entry has an authenticated identity matching the regex
+//
+// A nil regex matches every entry, including those with no identity
+func (self *Entry) IdentityMatches(regex *regexp.Regexp) bool {
+	if regex == nil {
+		return true
+	}
+	return self.identity != "" && regex.MatchString(self.identity)
+}
+
+// RemoteIP is the IP address the entry was received from
+func (self *Entry) RemoteIP() string {
+	return self.remoteIP
+}
+
+// RemotePort is the port the entry was received from, or 0 for unix sockets
+func (self *Entry) RemotePort() int {
+	return self.remotePort
+}
+
+// Received is the time the entry was received, in UTC
+func (self *Entry) Received() time.Time {
+	return self.received
+}
+
+// Time is the time of the entry in UTC: taken from the message if possible,
+// otherwise the time it was received
+func (self *Entry) Time() time.Time {
+	return self.time
+}
+
+// Version is the RFC 5424 protocol version, or 0 if the message did not follow RFC 5424
+func (self *Entry) Version() int {
+	return self.version
+}
+
+// Hostname is the HOSTNAME header field, or "" if none was supplied
+func (self *Entry) Hostname() string {
+	return self.hostname
+}
+
+// AppName is the APP-NAME header field, or "" if none was supplied
+func (self *Entry) AppName() string {
+	return self.appName
+}
+
+// ProcID is the PROCID header field, or "" if none was supplied
+func (self *Entry) ProcID() string {
+	return self.procID
+}
+
+// MsgID is the MSGID header field, or "" if none was supplied
+func (self *Entry) MsgID() string {
+	return self.msgID
+}
+
+// StructuredData returns the SD-ELEMENTs of the message, or nil if there were none
+func (self *Entry) StructuredData() StructuredData {
+	return self.sd
+}
+
+// Raw is the message exactly as it was received, including the PRI and header
+func (self *Entry) Raw() string {
+	return self.raw
+}
+
+// Text is the free-form message text with header fields removed
+func (self *Entry) Text() string {
+	return self.text
+}
+
+// header returns the supplied header fields in a form similar to
+// traditional syslog output: "hostname app[procid] msgid"
+func (self *Entry) header() string {
+	parts := make([]string, 0, 3)
+	if self.hostname != "" {
+		parts = append(parts, self.hostname)
+	}
+	switch {
+	case self.procID == "":
+		if self.appName != "" {
+			parts = append(parts, self.appName)
+		}
+	case self.appName == "":
+		parts = append(parts, "["+self.procID+"]")
+	default:
+		parts = append(parts, self.appName+"["+self.procID+"]")
+	}
+	if self.msgID != "" {
+		parts = append(parts, self.msgID)
+	}
+	return strings.Join(parts, " ")
+}
+
+// source returns the remote IP ("local" for unix sockets) followed by the sender's
+// identity or credentials (if known)
+func (self *Entry) source() string {
+	s := self.remoteIP
+	if s == "" {
+		s = "local"
+	}
+	if self.alias != "" {
+		s += " (" + self.alias + ")"
+	} else if self.remoteName != "" {
+		s += " (" + self.remoteName + ")"
+	}
+	if self.identity != "" {
+		s += " [" + self.identity + "]"
+	}
+	if self.hasCred {
+		s += fmt.Sprintf(" [pid=%d uid=%d gid=%d]", self.pid, self.uid, self.gid)
+	}
+	return s
+}
+
+// Message returns the header fields and structured data (if any) followed by the text
+func (self *Entry) Message() string {
+	m := self.text
+	if self.sd != nil {
+		m = strings.TrimSpace(self.sd.String() + " " + m)
+	}
+	if self.truncated {
+		m += " [truncated]"
+	}
+	if h := self.header(); h != "" {
+		return h + ": " + m
+	}
+	return m
+}
+
+// Matches returns true if the regex matches the message or any of the
+// structured data parameters in the form "SD-ID name=value"
+//
 // A nil regex matches everything
 func (self *Entry) Matches(regex *regexp.Regexp) bool {
 	if regex == nil {
 		return true
-	} else {
-		return regex.MatchString(self.text)
 	}
+	if regex.MatchString(self.Message()) {
+		return true
+	}
+	for _, s := range self.sd.Strings() {
+		if regex.MatchString(s) {
+			return true
+		}
+	}
+	return false
 }
 
 func (self *Entry) String() string {
 	if self.hasSeverity {
 		return fmt.Sprintf("%s %s %s/%s: %s",
 			self.time.Format(time.RFC3339),
-			self.remoteIP,
+			self.source(),
 			self.severity,
 			self.facility,
-			self.text)
+			self.Message())
 	} else {
 		return fmt.Sprintf("%s %s: %s",
 			self.time.Format(time.RFC3339),
-			self.remoteIP,
-			self.text)
+			self.source(),
+			self.Message())
 	}
 }
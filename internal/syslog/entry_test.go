@@ -16,11 +16,12 @@ package syslog_test
 
 import (
 	"net"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
 
-	syslog "github.com/m-z-b/syslogqd/syslog"
+	syslog "github.com/m-z-b/syslogqd/internal/syslog"
 )
 
 func TestNewEntry1(t *testing.T) {
@@ -69,12 +70,76 @@ func TestNewEntry2(t *testing.T) {
 	if strings.Index(s, "2003-10-11T22:14:15Z") != 0 {
 		t.Error("entry did not parse time correctly")
 	}
-	// The "1" at the beginning is an (optional) priority
-	if strings.Index(s, "1 mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8") == -1 {
+	// The "1" at the beginning is the version and the header fields are extracted
+	if e.Version() != 1 {
+		t.Errorf("got version %d, wanted 1", e.Version())
+	}
+	if e.Hostname() != "mymachine.example.com" || e.AppName() != "su" || e.ProcID() != "" || e.MsgID() != "ID47" {
+		t.Errorf("header fields not parsed correctly: %q %q %q %q", e.Hostname(), e.AppName(), e.ProcID(), e.MsgID())
+	}
+	if e.Text() != "BOM'su root' failed for lonvick on /dev/pts/8" {
+		t.Errorf("got text %q", e.Text())
+	}
+	if strings.Index(s, "mymachine.example.com su ID47: BOM'su root' failed for lonvick on /dev/pts/8") == -1 {
 		t.Error("entry did not preserve message correctly")
 	}
 }
 
+// RFC 5424 messages with a real BOM and NILVALUE fields
+func TestNewEntryRFC5424(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	raw := []byte("<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - \xEF\xBB\xBF%% It's time to make the do-nuts.")
+
+	e := syslog.NewEntry(raw, addr)
+	if e.Hostname() != "192.0.2.1" || e.AppName() != "myproc" || e.ProcID() != "8710" || e.MsgID() != "" {
+		t.Errorf("header fields not parsed correctly: %q %q %q %q", e.Hostname(), e.AppName(), e.ProcID(), e.MsgID())
+	}
+	if e.Text() != "%% It's time to make the do-nuts." {
+		t.Errorf("BOM or structured data not removed: %q", e.Text())
+	}
+	if s := e.String(); strings.Index(s, "2003-08-24T12:14:15Z") != 0 {
+		t.Errorf("entry did not parse time correctly: %q", s)
+	}
+
+	// RFC 5424 example 3, with structured data before the BOM
+	e = syslog.NewEntry([]byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 `+
+		`[exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"] `+
+		"\xEF\xBB\xBFAn application event log entry..."), addr)
+	if e.AppName() != "evntslog" || e.MsgID() != "ID47" || len(e.StructuredData()) != 1 {
+		t.Errorf("header fields not parsed correctly: %q %q %v", e.AppName(), e.MsgID(), e.StructuredData())
+	}
+	if e.Text() != "An application event log entry..." {
+		t.Errorf("BOM not removed after structured data: %q", e.Text())
+	}
+
+	// A NILVALUE timestamp means we use the received time
+	e = syslog.NewEntry([]byte("<34>1 - - - - - -"), addr)
+	if e.Version() != 1 || e.Hostname() != "" || e.Text() != "" {
+		t.Errorf("NILVALUE fields not parsed correctly: %q", e.String())
+	}
+	if strings.Index(e.String(), "2003") == 0 {
+		t.Error("entry did not use received time")
+	}
+}
+
+// Messages which look a little like RFC 5424 are handled as before
+func TestNewEntryNotRFC5424(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	for _, raw := range []string{
+		"1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - no priority",
+		"<34>01 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - leading zero",
+		"<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47",
+	} {
+		e := syslog.NewEntry([]byte(raw), addr)
+		if e.Version() != 0 || e.Hostname() != "" {
+			t.Errorf("%q should not be parsed as RFC 5424", raw)
+		}
+		if strings.Index(e.Text(), "mymachine.example.com su - ID47") == -1 {
+			t.Errorf("%q: entry did not preserve message correctly", raw)
+		}
+	}
+}
+
 // We try and check that fundamental information is extracted and displayed, but not how
 // exactly it is displayed
 func TestNewEntry3(t *testing.T) {
@@ -134,3 +199,61 @@ func TestNewEntry4(t *testing.T) {
 		t.Error("entry did not preserve message correctly")
 	}
 }
+
+// The authenticated identity is shown next to the IP address and can be matched
+func TestEntryIdentity(t *testing.T) {
+	addr, _ := net.ResolveTCPAddr("tcp", "192.168.1.99:5000")
+	e := syslog.NewEntry([]byte("<34>kitchen-relay says hello"), addr)
+	if e.IdentityMatches(regexp.MustCompile("kitchen-relay")) {
+		t.Error("unauthenticated entry matched identity")
+	}
+	e.SetIdentity("kitchen-relay")
+	if strings.Index(e.String(), "192.168.1.99 [kitchen-relay] ") == -1 {
+		t.Errorf("identity not shown: %q", e.String())
+	}
+	if !e.IdentityMatches(regexp.MustCompile("^kitchen-relay$")) || !e.IdentityMatches(nil) {
+		t.Error("authenticated entry did not match identity")
+	}
+}
+
+func TestEntryTruncated(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	e := syslog.NewEntry([]byte("<34>the start of a long message"), addr)
+	if e.Truncated() || strings.Index(e.String(), "truncated") != -1 {
+		t.Error("entry should not be truncated")
+	}
+	e.SetTruncated()
+	if !e.Truncated() || !strings.HasSuffix(e.String(), "the start of a long message [truncated]") {
+		t.Errorf("truncated entry not flagged: %q", e.String())
+	}
+}
+
+func TestEntryRaw(t *testing.T) {
+	raw := []byte("<34>2022-06-06T13:44:58Z   extra   spaces")
+	e := syslog.NewEntry(raw, nil)
+	if e.Raw() != "<34>2022-06-06T13:44:58Z   extra   spaces" || e.Text() != "extra spaces" {
+		t.Errorf("got raw %q, text %q", e.Raw(), e.Text())
+	}
+}
+
+func TestEntryAlias(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.49:5000")
+	e := syslog.NewEntry([]byte("<14>2022-06-06T13:44:58Z relay on"), addr)
+	e.SetAlias("kitchen-relay", []string{"shelly"})
+	if e.Alias() != "kitchen-relay" || len(e.Tags()) != 1 || e.String() != "2022-06-06T13:44:58Z 192.168.1.49 (kitchen-relay) info/user: relay on" {
+		t.Errorf("got alias %q, tags %v: %s", e.Alias(), e.Tags(), e)
+	}
+	if s := syslog.NewSyntheticEntry(e, "summary"); s.Alias() != "kitchen-relay" {
+		t.Errorf("synthetic entry did not keep the alias: %s", s)
+	}
+}
+
+func TestNewSyntheticEntry(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	like := syslog.NewEntry([]byte(`<34>1 2003-10-11T22:14:15.003Z host app 42 ID47 [a@1 b="c"] original`), addr)
+	e := syslog.NewSyntheticEntry(like, "a summary")
+	if e.RemoteIP() != "192.168.1.99" || e.Severity() != like.Severity() || e.Facility() != like.Facility() ||
+		e.MsgID() != "ID47" || e.Message() != "host app[42] ID47: a summary" || e.Time().Equal(like.Time()) {
+		t.Errorf("got %s", e)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"strconv"
+	"strings"
+)
+
+// pri returns the PRI part of a message, using user.notice if the entry didn't have one
+func (self *Entry) pri() string {
+	value := 1*8 + 5 // user.notice, as suggested by RFC 3164
+	if self.hasSeverity {
+		value = int(self.facility)*8 + int(self.severity)
+	}
+	return "<" + strconv.Itoa(value) + ">"
+}
+
+// forwardHostname is the hostname from the message, or if there wasn't one the
+// address of the sender
+func (self *Entry) forwardHostname() string {
+	if self.hostname != "" {
+		return self.hostname
+	}
+	return self.remoteIP
+}
+
+// RFC5424 formats the entry as an RFC 5424 message, keeping its PRI and timestamp,
+// so that it can be forwarded to another syslog server
+func (self *Entry) RFC5424() string {
+	b := strings.Builder{}
+	b.WriteString(self.pri())
+	b.WriteString("1 ")
+	b.WriteString(self.time.Format("2006-01-02T15:04:05.000000Z07:00"))
+	for i, field := range []string{self.forwardHostname(), self.appName, self.procID, self.msgID} {
+		b.WriteByte(' ')
+		b.WriteString(headerField(field, []int{255, 48, 128, 32}[i]))
+	}
+	b.WriteByte(' ')
+	if len(self.sd) == 0 {
+		b.WriteString(nilValue)
+	} else {
+		b.WriteString(self.sd.RFC5424())
+	}
+	if self.text != "" {
+		b.WriteByte(' ')
+		b.WriteString(self.text)
+	}
+	return b.String()
+}
+
+// RFC3164 formats the entry as a BSD syslog message, keeping its PRI and timestamp
+//
+// As BSD timestamps don't include a time zone or year, the time is given in local
+// time. Structured data is included at the start of the message.
+func (self *Entry) RFC3164() string {
+	b := strings.Builder{}
+	b.WriteString(self.pri())
+	b.WriteString(self.time.Local().Format("Jan _2 15:04:05"))
+	b.WriteByte(' ')
+	b.WriteString(headerField(self.forwardHostname(), 255))
+	if self.appName != "" {
+		b.WriteByte(' ')
+		b.WriteString(strings.Map(func(r rune) rune {
+			if r == '[' || r == ']' || r == ':' {
+				return '_'
+			}
+			return r
+		}, headerField(self.appName, 48)))
+		if self.procID != "" {
+			b.WriteString("[" + strings.ReplaceAll(headerField(self.procID, 128), "]", "_") + "]")
+		}
+		b.WriteByte(':')
+	}
+	if len(self.sd) > 0 {
+		b.WriteByte(' ')
+		b.WriteString(self.sd.String())
+	}
+	if self.text != "" {
+		b.WriteByte(' ')
+		b.WriteString(self.text)
+	}
+	return b.String()
+}
+
+// headerField converts s to a valid RFC 5424 header field: the NILVALUE if it is
+// empty, otherwise at most maxLen characters with anything other than printable
+// US-ASCII replaced by underscores
+func headerField(s string, maxLen int) string {
+	if s == "" {
+		return nilValue
+	}
+	if len(s) > maxLen {
+		s = s[:maxLen]
+	}
+	if isHeaderField(s, maxLen) {
+		return s
+	}
+	b := []byte(s)
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	syslog "github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func TestRFC5424RoundTrip(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	raw := `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" path="C:\\x \"y\" \]"] An event`
+	e := syslog.NewEntry([]byte(raw), addr)
+	wire := e.RFC5424()
+	if wanted := `<165>1 2003-10-11T22:14:15.003000Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" path="C:\\x \"y\" \]"] An event`; wire != wanted {
+		t.Errorf("got\n%s\nwanted\n%s", wire, wanted)
+	}
+	if again := syslog.NewEntry([]byte(wire), addr); again.Message() != e.Message() || !again.Time().Equal(e.Time()) {
+		t.Errorf("round trip gave %s, wanted %s", again, e)
+	}
+
+	// A message without a PRI or hostname gets user.notice and the sender's address
+	e = syslog.NewEntry([]byte("plain text"), addr)
+	wire = e.RFC5424()
+	if wanted := "<13>1 " + e.Time().Format("2006-01-02T15:04:05.000000Z") + " 192.168.1.99 - - - - plain text"; wire != wanted {
+		t.Errorf("got %s, wanted %s", wire, wanted)
+	}
+}
+
+func TestRFC3164RoundTrip(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	now := time.Now().Truncate(time.Second)
+	raw := "<34>1 " + now.Format(time.RFC3339) + " router sshd 4242 - - Failed password"
+	e := syslog.NewEntry([]byte(raw), addr)
+	wire := e.RFC3164()
+	if wanted := "<34>" + now.Local().Format("Jan _2 15:04:05") + " router sshd[4242]: Failed password"; wire != wanted {
+		t.Errorf("got %s, wanted %s", wire, wanted)
+	}
+	again := syslog.NewEntry([]byte(wire), addr)
+	if again.Hostname() != "router" || again.AppName() != "sshd" || again.ProcID() != "4242" ||
+		again.Text() != "Failed password" || !again.Time().Equal(e.Time()) || again.Severity() != e.Severity() {
+		t.Errorf("round trip gave %s, wanted %s", again, e)
+	}
+}
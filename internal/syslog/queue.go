@@ -0,0 +1,145 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy decides what happens when an entry is sent to a full Queue
+type OverflowPolicy uint8
+
+const (
+	Block      OverflowPolicy = iota // Wait until there is room
+	DropNewest                       // Discard the entry being sent
+	DropOldest                       // Discard the oldest queued entry to make room
+)
+
+var policyNames = []string{"block", "drop-newest", "drop-oldest"}
+
+func (p OverflowPolicy) String() string {
+	if int(p) < len(policyNames) {
+		return policyNames[p]
+	}
+	return fmt.Sprintf("policy(%d)!", int(p))
+}
+
+// ParseOverflowPolicy converts a string such as "drop-oldest" into an OverflowPolicy
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for i, name := range policyNames {
+		if strings.EqualFold(s, name) {
+			return OverflowPolicy(i), nil
+		}
+	}
+	return Block, fmt.Errorf("Unknown overflow policy \"%s\" (should be one of %s)", s, strings.Join(policyNames, ", "))
+}
+
+// A Queue passes entries from listeners to a reporter, counting them as it does so
+//
+// If the reporter can't keep up, the queue fills and the OverflowPolicy decides
+// whether listeners wait or entries are dropped. Listeners which wait can't read
+// from the network, so the operating system will drop messages instead and nobody
+// will know.
+type Queue struct {
+	entries  Channel
+	policy   OverflowPolicy
+	received atomic.Uint64
+	dropped  atomic.Uint64
+	limiter  *RateLimiter // nil if rates are not limited
+}
+
+// NewQueue returns a queue which can hold size entries
+func NewQueue(size int, policy OverflowPolicy) *Queue {
+	return &Queue{entries: make(Channel, size), policy: policy}
+}
+
+// Send adds an entry to the queue, applying the overflow policy if it is full
+//
+// Send may be called from several goroutines at once
+func (self *Queue) Send(e *Entry) {
+	self.received.Add(1)
+	if self.limiter != nil && !self.limiter.Allow(e, time.Now()) {
+		return
+	}
+	self.enqueue(e)
+}
+
+// enqueue adds an entry to the queue, applying the overflow policy if it is full
+func (self *Queue) enqueue(e *Entry) {
+	switch self.policy {
+	case DropNewest:
+		select {
+		case self.entries <- e:
+		default:
+			self.dropped.Add(1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case self.entries <- e:
+				return
+			default:
+			}
+			select {
+			case <-self.entries:
+				self.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		self.entries <- e
+	}
+}
+
+// LimitRate suppresses entries from sources which exceed the limiter's rate, and
+// every interval queues summaries of the entries which were suppressed
+//
+// LimitRate must be called before any entries are sent
+func (self *Queue) LimitRate(limiter *RateLimiter, interval time.Duration) {
+	self.limiter = limiter
+	go func() {
+		for now := range time.Tick(interval) {
+			for _, summary := range limiter.Summaries(now) {
+				self.enqueue(summary)
+			}
+		}
+	}()
+}
+
+// Entries returns the channel from which queued entries are received
+func (self *Queue) Entries() Channel {
+	return self.entries
+}
+
+// Received is the number of entries sent to the queue
+func (self *Queue) Received() uint64 {
+	return self.received.Load()
+}
+
+// Dropped is the number of entries discarded because the queue was full
+func (self *Queue) Dropped() uint64 {
+	return self.dropped.Load()
+}
+
+// Suppressed is the number of entries discarded because their source exceeded its rate
+func (self *Queue) Suppressed() uint64 {
+	if self.limiter == nil {
+		return 0
+	}
+	return self.limiter.Suppressed()
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog_test
+
+import (
+	"net"
+	"testing"
+
+	syslog "github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+// fill sends messages "1", "2", ... "n" to a queue of size 2 and returns the queued text
+func fill(policy syslog.OverflowPolicy, n int) (*syslog.Queue, []string) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	q := syslog.NewQueue(2, policy)
+	for i := 1; i <= n; i++ {
+		q.Send(syslog.NewEntry([]byte{byte('0' + i)}, addr))
+	}
+	var r []string
+	for len(q.Entries()) > 0 {
+		r = append(r, (<-q.Entries()).Text())
+	}
+	return q, r
+}
+
+func TestQueueOverflow(t *testing.T) {
+	q, got := fill(syslog.DropNewest, 4)
+	if len(got) != 2 || got[0] != "1" || got[1] != "2" || q.Received() != 4 || q.Dropped() != 2 {
+		t.Errorf("drop-newest: got %q, received %d, dropped %d", got, q.Received(), q.Dropped())
+	}
+	q, got = fill(syslog.DropOldest, 4)
+	if len(got) != 2 || got[0] != "3" || got[1] != "4" || q.Received() != 4 || q.Dropped() != 2 {
+		t.Errorf("drop-oldest: got %q, received %d, dropped %d", got, q.Received(), q.Dropped())
+	}
+	q, got = fill(syslog.Block, 2)
+	if len(got) != 2 || q.Received() != 2 || q.Dropped() != 0 {
+		t.Errorf("block: got %q, received %d, dropped %d", got, q.Received(), q.Dropped())
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, s := range []string{"block", "Drop-Newest", "drop-oldest"} {
+		p, err := syslog.ParseOverflowPolicy(s)
+		if err != nil {
+			t.Error(err)
+		} else if p.String() != s && p.String() != "drop-newest" {
+			t.Errorf("%q parsed as %s", s, p)
+		}
+	}
+	if _, err := syslog.ParseOverflowPolicy("drop"); err == nil {
+		t.Error("expected error parsing \"drop\"")
+	}
+}
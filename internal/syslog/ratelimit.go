@@ -0,0 +1,130 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A RateLimiter limits the rate of entries from each source using token buckets
+//
+// Each source (a remote IP address, or an address and app-name) has a bucket holding
+// up to burst tokens, which is refilled at rate tokens per second. An entry uses one
+// token, and is suppressed if the bucket is empty. Sources which send slowly are
+// never limited, but a source sending a flood is limited to rate entries per second
+// once its burst has been used.
+//
+// A RateLimiter may be used from several goroutines at once.
+type RateLimiter struct {
+	mu         sync.Mutex
+	rate       float64 // Tokens added per second
+	burst      float64
+	byApp      bool
+	buckets    map[string]*bucket
+	suppressed atomic.Uint64
+}
+
+type bucket struct {
+	tokens     float64
+	last       time.Time // When tokens was calculated
+	suppressed uint64    // Since the last summary
+	mostSevere *Entry    // The most severe entry suppressed since the last summary
+}
+
+// NewRateLimiter returns a RateLimiter allowing rate entries per second from each
+// source with bursts of up to burst entries. If byApp is true, each app-name from an
+// address has its own limit.
+func NewRateLimiter(rate float64, burst int, byApp bool) *RateLimiter {
+	return &RateLimiter{rate: rate, burst: float64(max(burst, 1)), byApp: byApp, buckets: make(map[string]*bucket)}
+}
+
+// source returns the name of the source of an entry
+func (self *RateLimiter) source(e *Entry) string {
+	source := e.RemoteIP()
+	if source == "" {
+		source = "local"
+	}
+	if self.byApp && e.AppName() != "" {
+		source += " " + e.AppName()
+	}
+	return source
+}
+
+// Allow returns true if the entry should be passed on, or false if it has been
+// suppressed because its source has exceeded its rate
+func (self *RateLimiter) Allow(e *Entry, now time.Time) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	k := self.source(e)
+	b, ok := self.buckets[k]
+	if !ok {
+		b = &bucket{tokens: self.burst, last: now}
+		self.buckets[k] = b
+	}
+	if now.After(b.last) {
+		b.tokens = min(self.burst, b.tokens+now.Sub(b.last).Seconds()*self.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	b.suppressed++
+	if b.mostSevere == nil || (e.HasSeverity() && (!b.mostSevere.HasSeverity() || !b.mostSevere.Severity().AsOrMoreSevereThan(e.Severity()))) {
+		b.mostSevere = e
+	}
+	self.suppressed.Add(1)
+	return false
+}
+
+// Suppressed is the total number of entries suppressed
+func (self *RateLimiter) Suppressed() uint64 {
+	return self.suppressed.Load()
+}
+
+// Summaries returns an entry such as "57 messages suppressed from 10.0.0.5" for each
+// source with entries suppressed since the last call. Each summary has the severity
+// of the most severe entry suppressed, so it passes the same severity filters.
+//
+// Sources which have not sent anything for long enough to refill their bucket are
+// forgotten.
+func (self *RateLimiter) Summaries(now time.Time) []*Entry {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	var summaries []*Entry
+	var sources []string
+	for k, b := range self.buckets {
+		if b.suppressed > 0 {
+			sources = append(sources, k)
+		} else if b.tokens+now.Sub(b.last).Seconds()*self.rate >= self.burst {
+			delete(self.buckets, k)
+		}
+	}
+	sort.Strings(sources)
+	for _, k := range sources {
+		b := self.buckets[k]
+		messages := "messages"
+		if b.suppressed == 1 {
+			messages = "message"
+		}
+		summaries = append(summaries, NewSyntheticEntry(b.mostSevere, fmt.Sprintf("%d %s suppressed from %s", b.suppressed, messages, k)))
+		b.suppressed, b.mostSevere = 0, nil
+	}
+	return summaries
+}
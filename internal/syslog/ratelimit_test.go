@@ -0,0 +1,100 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	syslog "github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func TestRateLimiter(t *testing.T) {
+	a, _ := net.ResolveUDPAddr("udp", "10.0.0.1:514")
+	b, _ := net.ResolveUDPAddr("udp", "10.0.0.2:514")
+	l := syslog.NewRateLimiter(10, 5, false) // 10/s with bursts of 5
+	start := time.Now()
+
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if l.Allow(syslog.NewEntry([]byte("<14>flood"), a), start) {
+			allowed++
+		}
+	}
+	if allowed != 5 || l.Suppressed() != 15 {
+		t.Errorf("burst: allowed %d, suppressed %d", allowed, l.Suppressed())
+	}
+	if !l.Allow(syslog.NewEntry([]byte("<14>quiet"), b), start) {
+		t.Error("other sources should not be limited")
+	}
+	l.Allow(syslog.NewEntry([]byte("<11>an error in the flood"), a), start)
+
+	// 0.3s later, 3 more entries are allowed
+	allowed = 0
+	for i := 0; i < 5; i++ {
+		if l.Allow(syslog.NewEntry([]byte("<14>flood"), a), start.Add(300*time.Millisecond)) {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("refill: allowed %d, wanted 3", allowed)
+	}
+
+	summaries := l.Summaries(start.Add(time.Second))
+	if len(summaries) != 1 || summaries[0].Text() != "18 messages suppressed from 10.0.0.1" ||
+		summaries[0].RemoteIP() != "10.0.0.1" || summaries[0].Severity() != 3 {
+		t.Errorf("got summaries %v", summaries)
+	}
+	if summaries := l.Summaries(start.Add(2 * time.Second)); len(summaries) != 0 {
+		t.Errorf("counts should be reset: %v", summaries)
+	}
+}
+
+func TestRateLimiterByApp(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "10.0.0.1:514")
+	l := syslog.NewRateLimiter(1, 1, true)
+	now := time.Now()
+	if !l.Allow(syslog.NewEntry([]byte("<14>Oct 11 22:14:15 host app1: a"), addr), now) ||
+		!l.Allow(syslog.NewEntry([]byte("<14>Oct 11 22:14:15 host app2: b"), addr), now) ||
+		l.Allow(syslog.NewEntry([]byte("<14>Oct 11 22:14:15 host app1: c"), addr), now) {
+		t.Error("each app should have its own limit")
+	}
+	if s := l.Summaries(now); len(s) != 1 || s[0].Text() != "1 message suppressed from 10.0.0.1 app1" {
+		t.Errorf("got summaries %v", s)
+	}
+}
+
+func TestQueueRateLimit(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "10.0.0.1:514")
+	q := syslog.NewQueue(10, syslog.DropNewest)
+	q.LimitRate(syslog.NewRateLimiter(1, 2, false), 50*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		q.Send(syslog.NewEntry([]byte("<14>flood"), addr))
+	}
+	if q.Received() != 5 || q.Suppressed() != 3 || len(q.Entries()) != 2 {
+		t.Errorf("received %d, suppressed %d, queued %d", q.Received(), q.Suppressed(), len(q.Entries()))
+	}
+	<-q.Entries()
+	<-q.Entries()
+	select {
+	case e := <-q.Entries():
+		if e.Text() != "3 messages suppressed from 10.0.0.1" {
+			t.Errorf("got %s", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("no summary")
+	}
+}
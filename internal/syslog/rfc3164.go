@@ -0,0 +1,128 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// BSD style timestamp "Mmm dd hh:mm:ss" with an optional year, fraction and time zone.
+	// Cisco devices may prefix the time with '*' or '.' and follow it with ':'
+	rBSDTimeStamp = regexp.MustCompile(`^ *[*.]?(Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) +(\d{1,2}) +` +
+		`(?:(\d{4}) +)?(\d\d):(\d\d):(\d\d)(\.\d{1,9})?(?: +(UTC|GMT|Z|[+-]\d\d:?\d\d))?:?(?: +|$)`)
+	// TAG[PID]: at the start of the message
+	rBSDTag = regexp.MustCompile(`^([^\s\[\]:]{1,48})(?:\[([^\]\s]{1,128})\])?:(?: +|$)`)
+)
+
+// months in the order used by time.Month
+var months = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// parseRFC3164 parses the remainder of a message following the PRI as
+//
+//	TIMESTAMP SP HOSTNAME SP TAG[PID]: MSG
+//
+// where the HOSTNAME and TAG are optional, although there can only be a HOSTNAME if
+// it is followed by a TAG or a ':'. If s does not start with a BSD
+// timestamp the entry is left unchanged and false is returned
+func (self *Entry) parseRFC3164(s string) bool {
+	m := rBSDTimeStamp.FindStringSubmatch(s)
+	if m == nil {
+		return false
+	}
+	t, ok := bsdTime(m, self.time)
+	if !ok {
+		return false
+	}
+	self.time = t.UTC()
+	s = s[len(m[0]):]
+
+	// If the first word is not a tag, it is the hostname if a tag or ':' follows it:
+	// otherwise it is just the start of the text, as in "Connection refused"
+	if !rBSDTag.MatchString(s) {
+		if i := strings.IndexByte(s, ' '); i > 0 && isHeaderField(s[:i], 255) {
+			rest := strings.TrimLeft(s[i:], " ")
+			if rBSDTag.MatchString(rest) || strings.HasPrefix(rest, ":") {
+				self.hostname = s[:i]
+				s = strings.TrimLeft(strings.TrimPrefix(rest, ":"), " ")
+			}
+		}
+	}
+	if tag := rBSDTag.FindStringSubmatch(s); tag != nil {
+		self.appName = tag[1]
+		self.procID = tag[2]
+		s = s[len(tag[0]):]
+	}
+	self.setText(s)
+	return true
+}
+
+// bsdTime converts the submatches of rBSDTimeStamp to a time
+//
+// Times without a zone are assumed to be local. If there is no year, we choose the one
+// which puts the time closest to the time it was received: allowing for a month of
+// clock error, a December message received in January is from last year and vice versa
+func bsdTime(m []string, received time.Time) (time.Time, bool) {
+	month := time.January
+	for i, name := range months {
+		if m[1] == name {
+			month = time.Month(i + 1)
+		}
+	}
+	day, _ := strconv.Atoi(m[2])
+	hour, _ := strconv.Atoi(m[4])
+	min, _ := strconv.Atoi(m[5])
+	sec, _ := strconv.Atoi(m[6])
+	nsec := 0
+	if m[7] != "" {
+		nsec, _ = strconv.Atoi((m[7][1:] + "00000000")[:9])
+	}
+	if day < 1 || day > 31 || hour > 23 || min > 59 || sec > 60 {
+		return time.Time{}, false
+	}
+
+	loc := time.Local
+	switch zone := strings.Replace(m[8], ":", "", 1); zone {
+	case "":
+	case "UTC", "GMT", "Z":
+		loc = time.UTC
+	default:
+		h, _ := strconv.Atoi(zone[1:3])
+		mins, _ := strconv.Atoi(zone[3:5])
+		offset := h*3600 + mins*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone(zone, offset)
+	}
+
+	if m[3] != "" {
+		year, _ := strconv.Atoi(m[3])
+		return time.Date(year, month, day, hour, min, sec, nsec, loc), true
+	}
+	const slack = 31 * 24 * time.Hour
+	year := received.Year()
+	t := time.Date(year, month, day, hour, min, sec, nsec, loc)
+	switch {
+	case t.After(received.Add(slack)):
+		year--
+	case t.Before(received.Add(slack - 365*24*time.Hour)):
+		year++
+	}
+	return time.Date(year, month, day, hour, min, sec, nsec, loc), true
+}
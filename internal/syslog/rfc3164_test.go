@@ -0,0 +1,82 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	syslog "github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+var bsdExamples = []struct {
+	raw      string
+	time     string // Expected time in RFC3339 format, UTC
+	hostname string
+	appName  string
+	procID   string
+	text     string
+}{
+	{"<13>Oct 11 2022 22:14:15 UTC host tag[123]: msg", "2022-10-11T22:14:15Z", "host", "tag", "123", "msg"},
+	{"<13>Oct  1 2022 22:14:15.123 +01:00 host tag: msg text", "2022-10-01T21:14:15Z", "host", "tag", "", "msg text"},
+	{"<13>Oct 11 2021 22:14:15 -0500 su: 'su root' failed", "2021-10-12T03:14:15Z", "", "su", "", "'su root' failed"},
+	{"<189>*Mar  1 2020 18:48:50.483 UTC: %SYS-5-CONFIG_I: Configured", "2020-03-01T18:48:50Z", "", "%SYS-5-CONFIG_I", "", "Configured"},
+	{"<13>Oct 11 2022 22:14:15 GMT mymachine no tag here", "2022-10-11T22:14:15Z", "", "", "", "mymachine no tag here"},
+	{"<13>Oct 11 2022 22:14:15 UTC Connection refused", "2022-10-11T22:14:15Z", "", "", "", "Connection refused"},
+	{"<13>Oct 11 2022 22:14:15 UTC router : link down", "2022-10-11T22:14:15Z", "router", "", "", "link down"},
+}
+
+func TestNewEntryRFC3164(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	for _, ex := range bsdExamples {
+		e := syslog.NewEntry([]byte(ex.raw), addr)
+		if strings.Index(e.String(), ex.time) != 0 {
+			t.Errorf("%q: wanted time %s, got %q", ex.raw, ex.time, e.String())
+		}
+		if e.Hostname() != ex.hostname || e.AppName() != ex.appName || e.ProcID() != ex.procID {
+			t.Errorf("%q: header fields not parsed correctly: %q %q %q", ex.raw, e.Hostname(), e.AppName(), e.ProcID())
+		}
+		if e.Text() != ex.text {
+			t.Errorf("%q: got text %q", ex.raw, e.Text())
+		}
+	}
+}
+
+// Timestamps without a year are given the year which puts them closest to now
+func TestRFC3164Year(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	for _, ex := range []struct {
+		days     int // Offset of the message time from now
+		yearDiff int // Expected difference from the year of the message time
+	}{
+		{0, 0},
+		{-60, 0},
+		{10, 0},
+		{60, -1},
+		{-350, 1},
+	} {
+		when := time.Now().AddDate(0, 0, ex.days)
+		e := syslog.NewEntry([]byte("<13>"+when.Format(time.Stamp)+" host tag: msg"), addr)
+		wanted := when.Year() + ex.yearDiff
+		if got := e.Time().Local().Year(); got != wanted {
+			t.Errorf("message from %d days away: got year %d, wanted %d", ex.days, got, wanted)
+		}
+		if e.Hostname() != "host" || e.Text() != "msg" {
+			t.Errorf("message from %d days away was not parsed: %q", ex.days, e.String())
+		}
+	}
+}
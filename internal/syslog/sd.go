@@ -0,0 +1,199 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SDParam is a name/value pair within an SD-ELEMENT
+type SDParam struct {
+	Name  string
+	Value string // With escapes removed
+}
+
+// SDElement is an RFC 5424 SD-ELEMENT such as [exampleSDID@32473 iut="3"]
+type SDElement struct {
+	ID     string
+	Params []SDParam
+}
+
+// StructuredData holds the SD-ELEMENTs of a message in the order they were received
+type StructuredData []SDElement
+
+// Get returns the value of the named parameter in the element with the given SD-ID
+//
+// If the parameter is repeated, the first value is returned
+func (sd StructuredData) Get(id, name string) (string, bool) {
+	for _, e := range sd {
+		if e.ID != id {
+			continue
+		}
+		for _, p := range e.Params {
+			if p.Name == name {
+				return p.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Map returns the structured data as a map of SD-ID to parameter name to value
+//
+// Repeated parameters are joined with a comma
+func (sd StructuredData) Map() map[string]map[string]string {
+	m := make(map[string]map[string]string, len(sd))
+	for _, e := range sd {
+		params, ok := m[e.ID]
+		if !ok {
+			params = make(map[string]string, len(e.Params))
+			m[e.ID] = params
+		}
+		for _, p := range e.Params {
+			if v, ok := params[p.Name]; ok {
+				params[p.Name] = v + "," + p.Value
+			} else {
+				params[p.Name] = p.Value
+			}
+		}
+	}
+	return m
+}
+
+// Strings returns each parameter as "SD-ID name=value" (or just the SD-ID for
+// elements with no parameters) so that filters can match individual parameters
+func (sd StructuredData) Strings() []string {
+	r := make([]string, 0, len(sd))
+	for _, e := range sd {
+		if len(e.Params) == 0 {
+			r = append(r, e.ID)
+		}
+		for _, p := range e.Params {
+			r = append(r, e.ID+" "+p.Name+"="+p.Value)
+		}
+	}
+	return r
+}
+
+// String shows the structured data in a readable form: values are only quoted
+// if they are empty or contain characters which would make them ambiguous
+func (sd StructuredData) String() string {
+	b := strings.Builder{}
+	for _, e := range sd {
+		b.WriteString("[")
+		b.WriteString(e.ID)
+		for _, p := range e.Params {
+			b.WriteString(" ")
+			b.WriteString(p.Name)
+			b.WriteString("=")
+			if p.Value == "" || strings.ContainsAny(p.Value, " \t\"]\\") {
+				b.WriteString(strconv.Quote(p.Value))
+			} else {
+				b.WriteString(p.Value)
+			}
+		}
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+// RFC5424 returns the structured data in the form used by RFC 5424, with quoted and
+// escaped values
+func (sd StructuredData) RFC5424() string {
+	b := strings.Builder{}
+	for _, e := range sd {
+		b.WriteString("[")
+		b.WriteString(e.ID)
+		for _, p := range e.Params {
+			b.WriteString(" ")
+			b.WriteString(p.Name)
+			b.WriteString("=\"")
+			b.WriteString(sdEscaper.Replace(p.Value))
+			b.WriteString("\"")
+		}
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+// sdEscaper adds the escapes required in a PARAM-VALUE
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// parseStructuredData parses one or more SD-ELEMENTs at the start of s
+//
+// It returns the elements and the remainder of s, or ok=false if s does not start
+// with well formed structured data
+func parseStructuredData(s string) (sd StructuredData, rest string, ok bool) {
+	for len(s) > 0 && s[0] == '[' {
+		var e SDElement
+		if e.ID, s, ok = parseSDName(s[1:]); !ok {
+			return nil, "", false
+		}
+		for len(s) > 0 && s[0] == ' ' {
+			var p SDParam
+			if p.Name, s, ok = parseSDName(s[1:]); !ok {
+				return nil, "", false
+			}
+			if !strings.HasPrefix(s, "=\"") {
+				return nil, "", false
+			}
+			if p.Value, s, ok = parseParamValue(s[2:]); !ok {
+				return nil, "", false
+			}
+			e.Params = append(e.Params, p)
+		}
+		if len(s) == 0 || s[0] != ']' {
+			return nil, "", false
+		}
+		s = s[1:]
+		sd = append(sd, e)
+	}
+	return sd, s, len(sd) > 0
+}
+
+// parseSDName parses an SD-NAME: 1 to 32 printable US-ASCII characters
+// other than '=', ' ', ']' and '"'
+func parseSDName(s string) (name, rest string, ok bool) {
+	i := 0
+	for i < len(s) && i <= 32 && s[i] > 32 && s[i] < 127 && s[i] != '=' && s[i] != ']' && s[i] != '"' {
+		i++
+	}
+	if i == 0 || i > 32 {
+		return "", "", false
+	}
+	return s[:i], s[i:], true
+}
+
+// parseParamValue parses a PARAM-VALUE up to and including the closing quote,
+// removing the \", \\ and \] escapes. Any other backslash is kept as-is.
+func parseParamValue(s string) (value, rest string, ok bool) {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], true
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+				i++
+				c = s[i]
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", false // No closing quote
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 Mike Bell, Albion Research Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog_test
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+
+	syslog "github.com/m-z-b/syslogqd/internal/syslog"
+)
+
+func TestStructuredData(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	// Example from RFC5424
+	raw := []byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 ` +
+		`[exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high"]` +
+		` An application event log entry...`)
+
+	e := syslog.NewEntry(raw, addr)
+	sd := e.StructuredData()
+	if len(sd) != 2 {
+		t.Fatalf("got %d SD-ELEMENTs, wanted 2", len(sd))
+	}
+	if v, ok := sd.Get("exampleSDID@32473", "eventSource"); !ok || v != "Application" {
+		t.Errorf("got eventSource %q", v)
+	}
+	if v := sd.Map()["examplePriority@32473"]["class"]; v != "high" {
+		t.Errorf("got class %q", v)
+	}
+	if e.Text() != "An application event log entry..." {
+		t.Errorf("got text %q", e.Text())
+	}
+	if strings.Index(e.String(), `[exampleSDID@32473 iut=3 eventSource=Application eventID=1011][examplePriority@32473 class=high] An application`) == -1 {
+		t.Errorf("structured data not shown: %q", e.String())
+	}
+	if !e.Matches(regexp.MustCompile(`^examplePriority@32473 class=high$`)) {
+		t.Error("regex did not match SD-PARAM")
+	}
+}
+
+func TestStructuredDataEscapes(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	raw := []byte(`<13>1 - host app - - [origin@0 ip="10.0.0.1" q="a \"b\" \\ \] \x"][meta@0]`)
+
+	e := syslog.NewEntry(raw, addr)
+	if v, _ := e.StructuredData().Get("origin@0", "q"); v != `a "b" \ ] \x` {
+		t.Errorf("escapes not handled: %q", v)
+	}
+	if len(e.StructuredData()) != 2 || e.Text() != "" {
+		t.Errorf("got %d elements and text %q", len(e.StructuredData()), e.Text())
+	}
+	if !e.Matches(regexp.MustCompile(`origin@0 ip=10\.0\.0\.1`)) {
+		t.Error("regex did not match SD-PARAM")
+	}
+}
+
+// Badly formed structured data means the message is not treated as RFC 5424
+func TestBadStructuredData(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.168.1.99:5000")
+	for _, raw := range []string{
+		`<13>1 - host app - - [origin@0 ip="10.0.0.1" text`,
+		`<13>1 - host app - - [origin@0 ip=10.0.0.1] text`,
+		`<13>1 - host app - - [origin@0 ip="10.0.0.1"]text`,
+		`<13>1 - host app - - text`,
+	} {
+		e := syslog.NewEntry([]byte(raw), addr)
+		if e.Version() != 0 || e.StructuredData() != nil {
+			t.Errorf("%q should not be parsed as RFC 5424", raw)
+		}
+	}
+}
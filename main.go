@@ -15,15 +15,30 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
 	"regexp"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/m-z-b/syslogqd/internal/alias"
+	"github.com/m-z-b/syslogqd/internal/config"
+	"github.com/m-z-b/syslogqd/internal/facility"
+	"github.com/m-z-b/syslogqd/internal/filter"
+	"github.com/m-z-b/syslogqd/internal/forwarder"
 	"github.com/m-z-b/syslogqd/internal/listener"
+	"github.com/m-z-b/syslogqd/internal/logfile"
 	"github.com/m-z-b/syslogqd/internal/reporter"
+	"github.com/m-z-b/syslogqd/internal/resolver"
 	"github.com/m-z-b/syslogqd/internal/severity"
 	"github.com/m-z-b/syslogqd/internal/syslog"
 )
@@ -37,17 +52,102 @@ const (
 // Command line arguments
 // (note that these are displayed alphabetically)
 var (
-	optPort     = flag.Int("port", 514, "port to listen on (UDP-only)")
-	optFilename = flag.String("file", "", "write output to file")
-	optQuiet    = flag.Bool("quiet", false, "do not write to standard output")
-	optSeverity = flag.String("severity", "debug", "minimum severity of events to report")
-	optRegex    = flag.String("regex", "", "Exclude events not matching this regular expression")
+	optConfig        = flag.String("config", "", "read options from this file (see README); options on the command line take precedence")
+	optCheckConfig   = flag.Bool("check-config", false, "check the -config file and exit")
+	optPort          = flag.Int("port", 514, "port to listen on for UDP and TCP (if no -listen options)")
+	optFilename      = flag.String("file", "", "write output to file")
+	optDir           = flag.String("dir", "", "write each entry to a file chosen by a template, e.g. logs/{{.RemoteIP}}/{{.Date}}.log")
+	optDirMaxOpen    = flag.Int("dir-max-open", reporter.DefaultMaxOpen, "maximum number of -dir files to keep open")
+	optRotateSize    = flag.String("rotate-size", "", "rotate -file when it reaches this size, e.g. 100M (default no limit)")
+	optRotateEvery   = flag.String("rotate-interval", "", "also rotate -file hourly or daily")
+	optRotatePattern = flag.String("rotate-pattern", "", "strftime-like name for rotated files, e.g. syslog-%Y%m%d-%H%M%S.log (default <file>.%Y%m%d-%H%M%S)")
+	optRotateKeep    = flag.Int("rotate-keep", 0, "number of rotated files to keep (0 = all)")
+	optRotateGzip    = flag.Bool("rotate-gzip", false, "compress rotated files with gzip")
+	optQuiet         = flag.Bool("quiet", false, "do not write to standard output")
+	optSeverity      = flag.String("severity", "debug", "minimum severity of events to report")
+	optFacility      = flag.String("facility", "", "only report events from these facilities, e.g. daemon,local0")
+	optNoFacility    = flag.String("exclude-facility", "", "Exclude events from these facilities, e.g. cron,kernel")
+	optRegex         = flag.String("regex", "", "Exclude events not matching this regular expression")
+	optFilter        = flag.String("filter", "", "only report events matching a filter expression, e.g. 'severity<=warning && host==\"10.0.0.5\"'")
+	optFilterFile    = flag.String("filter-file", "", "only report events matching the filter expression in this file")
+	optIncludeFile   = flag.String("include-file", "", "file of -include patterns, one per line")
+	optExcludeFile   = flag.String("exclude-file", "", "file of -exclude patterns, one per line")
+	optAliases       = flag.String("aliases", "", "file naming devices, one per line as SOURCE NAME [TAG...], e.g. \"192.168.1.49 kitchen-relay shelly\"")
+	optResolve       = flag.Bool("resolve", false, "show the names of sender IP addresses found by reverse DNS (looked up in the background)")
+	optResolveHosts  = flag.String("resolve-hosts", "", "find the names of sender IP addresses in this file in /etc/hosts format before using -resolve")
+	optResolveTTL    = flag.Duration("resolve-ttl", resolver.DefaultTTL, "how long -resolve names are cached")
+	optResolveNegTTL = flag.Duration("resolve-negative-ttl", resolver.DefaultNegativeTTL, "how long addresses without a -resolve name are cached")
+	optSourceFile    = flag.String("source-file", "", "file of -source rules, one per line")
+	optIgnoreCase    = flag.Bool("ignore-case", false, "make -regex, -include and -exclude patterns case-insensitive")
+	optMatch         = flag.String("match", "message", "what -include and -exclude patterns are matched against: message, line (as shown) or raw (as received)")
+	optDedup         = flag.Duration("dedup", 0, "collapse identical messages from a source which arrive within this time, e.g. 10s (0 = disabled)")
+	optDedupNumbers  = flag.Bool("dedup-numbers", false, "ignore numbers (such as counters and addresses) when looking for -dedup duplicates")
+	optDedupIgnore   = flag.String("dedup-ignore", "", "ignore text matching this regular expression when looking for -dedup duplicates")
+	optTLSPort       = flag.Int("tls-port", 0, "port to listen on for syslog over TLS (0 = disabled)")
+	optTLSCert       = flag.String("tls-cert", "", "PEM certificate file for -tls-port")
+	optTLSKey        = flag.String("tls-key", "", "PEM private key file for -tls-port")
+	optTLSGen        = flag.Bool("tls-generate", false, "create a self-signed -tls-cert and -tls-key if neither exists")
+	optTLSCA         = flag.String("tls-ca", "", "PEM CA bundle: TLS clients must present a certificate signed by one of these")
+	optUnix          = flag.String("unix", "", "unix domain socket to listen on for local messages (e.g. /run/syslogqd.sock)")
+	optUnixStream    = flag.Bool("unix-stream", false, "use a stream rather than a datagram socket for -unix")
+	optListen        = stringList{}
+	optHighlight     = stringList{}
+	optInclude       = stringList{}
+	optExclude       = stringList{}
+	optSource        = stringList{}
+	optColor         = flag.String("color", "auto", "colour standard output by severity and remote IP: auto (if a terminal), always or never")
+	optFormat        = flag.String("format", "text", "output format: text or json (one JSON object per line)")
+	optFileFormat    = flag.String("file-format", "", "output format for -file and -dir (default -format)")
+	optTemplate      = flag.String("template", "", "Go text/template for each line written to standard output (overrides -format)")
+	optFileTemplate  = flag.String("file-template", "", "Go text/template for each line written to -file and -dir (overrides -file-format)")
+	optQueueSize     = flag.Int("queue-size", 1000, "number of entries which can be waiting to be written")
+	optOverflow      = flag.String("overflow", "block", "what to do when the queue is full: block, drop-newest or drop-oldest")
+	optRate          = flag.Float64("rate", 0, "maximum messages per second from each source, after a -rate-burst (0 = unlimited)")
+	optRateBurst     = flag.Int("rate-burst", 100, "number of messages a source can send at once before -rate applies")
+	optRateByApp     = flag.Bool("rate-by-app", false, "apply -rate to each app-name from a source separately")
+	optRateReport    = flag.Duration("rate-report", 10*time.Second, "how often to report the number of messages suppressed by -rate")
+	optRcvBuf        = flag.Int("udp-rcvbuf", 0, "size in bytes of the kernel receive buffer for UDP listeners (0 = system default)")
+	optIdentity      = flag.String("identity", "", "Exclude events whose authenticated TLS client identity does not match this regular expression")
+	optForward       = flag.String("forward", "", "also send entries to a syslog server, e.g. udp://loghost, tcp://10.0.0.5:514 or tls://loghost:6514")
+	optForwardFormat = flag.String("forward-format", "rfc5424", "format for -forward: rfc5424 or rfc3164")
+	optForwardSpool  = flag.String("forward-spool", "", "file to hold entries while the -forward server is unreachable (default drop them)")
+	optForwardCA     = flag.String("forward-ca", "", "PEM CA bundle used to verify a tls:// -forward server (default system CAs)")
+	optForwardCert   = flag.String("forward-cert", "", "PEM client certificate to present to a tls:// -forward server")
+	optForwardKey    = flag.String("forward-key", "", "PEM private key for -forward-cert")
 )
 
+func init() {
+	flag.Var(&optSource, "source", "minimum severity and optional filter for one source, e.g. \"10.0.0.5 debug\", \"10.0.1.0/24 warning app!=ntpd\",\n\"host:printer error\" or \"app:sshd - text~fail\" (may be repeated; the first matching rule is used)")
+	flag.Var(&optInclude, "include", "only report events matching one of these regular expressions (may be repeated)")
+	flag.Var(&optExclude, "exclude", "Exclude events matching this regular expression (may be repeated)")
+	flag.Var(&optHighlight, "highlight", "highlight text matching this regular expression on standard output (may be repeated)")
+	flag.Var(&optListen, "listen", "address to listen on, e.g. udp://192.168.1.10:514, tcp://[::1], tls://:6514 or unixgram:///run/syslogqd.sock\n(may be repeated; replaces -port, -tls-port and -unix)")
+}
+
+// stringList is a command line option which may be repeated
+type stringList []string
+
+func (self *stringList) String() string {
+	return strings.Join(*self, ", ")
+}
+
+func (self *stringList) Set(s string) error {
+	*self = append(*self, s)
+	return nil
+}
+
+// IsRepeatable allows a list of values in a -config file
+func (self *stringList) IsRepeatable() bool {
+	return true
+}
+
 var (
-	output      *os.File                               // File to write to (if not null)
-	minSeverity severity.Severity = severity.Default() // Minimum severity to display
-	mustMatch   *regexp.Regexp                         // null or must match this to record
+	output       *logfile.File                          // File to write to (if not null)
+	minSeverity  severity.Severity = severity.Default() // Minimum severity to display
+	mustMatch    *regexp.Regexp                         // null or must match this to record
+	mustIdentify *regexp.Regexp                         // null or sender identity must match this to record
+
+	onCommandLine = map[string]bool{} // Options given on the command line rather than in the -config file
 )
 
 // FatalError prints a message followed by a newline to stderr and exits the program
@@ -96,65 +196,724 @@ func main() {
 		fmt.Fprintf(w, "Usage: %s V%s [options]\n", NAME, VERSION)
 		flag.PrintDefaults()
 		fmt.Fprintf(w, "\n Severity Values: %s\n", severity.PossibleValues())
+		fmt.Fprintf(w, "\n Facility Values: %s\n", facility.PossibleValues())
 	}
 
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
 
-	if *optPort <= 0 || *optPort > 65535 {
-		FatalError("-port must be in the range 1..65535")
+	configAliases := &alias.Current{}
+	configAliasTable, err := loadConfig()
+	configAliases.Store(configAliasTable)
+	if *optCheckConfig {
+		if *optConfig == "" {
+			FatalError("-check-config requires -config")
+		}
+		CheckForFatalError(err)
+		listenAddresses() // Exits if -listen conflicts with -port etc. in the file
+		fmt.Printf("%s: %s is valid\n", NAME, *optConfig)
+		os.Exit(0)
 	}
+	CheckForFatalError(err)
 
-	if *optFilename == "" && *optQuiet {
-		FatalError("Can only specify -quiet if -file is specified")
+	flag.VisitAll(func(f *flag.Flag) {
+		if err := checkLimit(f.Name, f.Value.String()); err != nil {
+			FatalError("-%s %s", f.Name, err)
+		}
+	})
+
+	addresses := listenAddresses()
+	hasTLS := false
+	for _, a := range addresses {
+		hasTLS = hasTLS || a.IsTLS()
 	}
 
-	var err error
+	if hasTLS && (*optTLSCert == "" || *optTLSKey == "") {
+		FatalError("TLS listeners require -tls-cert and -tls-key")
+	}
+
+	if *optFilename == "" && *optDir == "" && *optQuiet {
+		FatalError("Can only specify -quiet if -file or -dir is specified")
+	}
+
+	if *optFilename == "" && (*optRotateSize != "" || *optRotateEvery != "" || *optRotatePattern != "" || *optRotateKeep != 0 || *optRotateGzip) {
+		FatalError("The -rotate options require -file")
+	}
+
+	overflow, err := syslog.ParseOverflowPolicy(*optOverflow)
+	CheckForFatalError(err)
+
 	if *optSeverity != "" {
 		minSeverity, err = severity.Parse((*optSeverity))
 		CheckForFatalError(err)
 	}
 
 	if *optRegex != "" {
+		if *optIgnoreCase {
+			*optRegex = "(?i)" + *optRegex
+		}
 		mustMatch, err = regexp.Compile(*optRegex)
 		CheckForFatalErrorF(err, "Invalid regular expression: %s", err)
 	}
 
-	reporter := reporter.NewReporter(mustMatch)
+	eventFilter, err := loadFilter()
+	CheckForFatalError(err)
+
+	patterns, err := loadPatterns()
+	CheckForFatalError(err)
+
+	dedup, err := deduplicator()
+	CheckForFatalError(err)
+
+	sources := &filter.SourceRules{}
+	for _, rule := range optSource {
+		CheckForFatalError(sources.Add(rule))
+	}
+	if *optSourceFile != "" {
+		CheckForFatalError(sources.AddFile(*optSourceFile))
+	}
+
+	if *optIdentity != "" {
+		mustIdentify, err = regexp.Compile(*optIdentity)
+		CheckForFatalErrorF(err, "Invalid regular expression: %s", err)
+	}
+
+	if *optTLSCA != "" && !hasTLS {
+		FatalError("-tls-ca requires a TLS listener")
+	}
+
+	format, err := outputFormat(*optFormat, *optTemplate)
+	CheckForFatalError(err)
+	if *optFileFormat == "" {
+		*optFileFormat = *optFormat
+	}
+	fileFormat, err := outputFormat(*optFileFormat, *optFileTemplate)
+	CheckForFatalError(err)
+
+	color, err := reporter.UseColor(*optColor, os.Stdout)
+	CheckForFatalError(err)
+	if _, ok := format.(reporter.JSONFormat); ok {
+		color = false
+	}
+	highlights := make([]*regexp.Regexp, len(optHighlight))
+	for i, h := range optHighlight {
+		highlights[i], err = regexp.Compile(h)
+		CheckForFatalErrorF(err, "Invalid regular expression: %s", err)
+	}
+	var colors *reporter.Colorizer
+	if color {
+		colors = reporter.NewColorizer(highlights)
+	}
+
+	var deviceFiles *reporter.DeviceFiles
+	if *optDir != "" {
+		deviceFiles, err = reporter.NewDeviceFiles(*optDir, *optDirMaxOpen, func(err error) {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", NAME, err)
+		})
+		CheckForFatalError(err)
+	}
+
+	var aliases *alias.File
+	if *optAliases != "" {
+		aliases, err = alias.Open(*optAliases, func(err error) {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", NAME, err)
+		})
+		CheckForFatalError(err)
+		aliases.Watch(2 * time.Second)
+		defer aliases.Close()
+	}
+
+	var names *resolver.Resolver
+	if *optResolve || *optResolveHosts != "" {
+		names, err = resolver.New(resolver.Options{DNS: *optResolve, Hosts: *optResolveHosts,
+			TTL: *optResolveTTL, NegativeTTL: *optResolveNegTTL})
+		CheckForFatalError(err)
+		defer names.Close()
+	}
+
+	relay := startForwarder()
+	if relay != nil {
+		defer relay.Close()
+	}
+
+	// Keep standard output machine readable if it is in JSON format
+	banner := os.Stdout
+	if _, ok := format.(reporter.JSONFormat); ok {
+		banner = os.Stderr
+	}
+
+	reporter := reporter.NewReporter(mustMatch).RequireIdentity(mustIdentify).SetFilter(eventFilter).
+		SetPatterns(patterns).SetSourceRules(sources).Deduplicate(dedup)
+	if *optConfig != "" {
+		reporter.AddNamer(configAliases) // Before the -aliases file so that file takes precedence
+	}
+	if aliases != nil {
+		reporter.AddNamer(aliases)
+	}
+	if names != nil {
+		reporter.AddNamer(names)
+	}
 
 	if *optFilename != "" {
-		output, err = os.OpenFile(*optFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		output, err = logfile.Open(*optFilename, rotateOptions())
 		CheckForFatalErrorF(err, "Could not open %s: %s", *optFilename, err)
 		defer output.Close()
-		reporter.AddOutput(output)
+		reporter.AddOutput(output, fileFormat)
+	}
+
+	if deviceFiles != nil {
+		defer deviceFiles.Close()
+		reporter.AddEntryOutput(deviceFiles, fileFormat)
 	}
 
+	if relay != nil {
+		reporter.AddEntryOutput(relay, nil)
+	}
+
+	// Reopen files after an external program such as logrotate has renamed them,
+	// and reload the aliases and hosts file
+	reopenRequests := make(chan os.Signal, 1)
+	if len(reopenSignals) > 0 {
+		signal.Notify(reopenRequests, reopenSignals...)
+	}
+	go func() {
+		for range reopenRequests {
+			if output != nil {
+				if err := output.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: could not reopen %s: %s\n", NAME, *optFilename, err)
+				}
+			}
+			if deviceFiles != nil {
+				deviceFiles.Close() // They are reopened when written to
+			}
+			if *optConfig != "" {
+				if err := reloadConfigAliases(configAliases); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: could not reload aliases from %s: %s\n", NAME, *optConfig, err)
+				}
+			}
+			if aliases != nil {
+				if err := aliases.Reload(); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: could not reload aliases: %s\n", NAME, err)
+				}
+			}
+			if names != nil {
+				if err := names.ReloadHosts(); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: could not reload %s: %s\n", NAME, *optResolveHosts, err)
+				}
+			}
+		}
+	}()
+
 	if !*optQuiet {
-		fmt.Printf("%s V%s listening on port %d for severity >= %s\n", NAME, VERSION, *optPort, minSeverity)
+		fmt.Fprintf(banner, "%s V%s listening on %s for severity >= %s\n", NAME, VERSION, joinAddresses(addresses), minSeverity)
 		if *optRegex != "" {
-			fmt.Printf("Ignoring messages which don't match \"%s\"\n", *optRegex)
+			fmt.Fprintf(banner, "Ignoring messages which don't match \"%s\"\n", *optRegex)
+		}
+		if configAliases.Len() > 0 {
+			fmt.Fprintf(banner, "Naming devices using %d aliases from %s\n", configAliases.Len(), *optConfig)
+		}
+		if aliases != nil {
+			fmt.Fprintf(banner, "Naming devices using %d aliases from %s\n", aliases.Len(), *optAliases)
+		}
+		if sources.Len() > 0 {
+			fmt.Fprintf(banner, "Using %d -source rules instead of -severity for the sources they match\n", sources.Len())
+		}
+		if patterns.Len() > 0 {
+			fmt.Fprintf(banner, "Ignoring messages according to %d -include and -exclude patterns\n", patterns.Len())
+		}
+		if eventFilter != nil {
+			fmt.Fprintf(banner, "Ignoring messages which don't pass the filter %s\n", eventFilter)
+		}
+		if *optIdentity != "" {
+			fmt.Fprintf(banner, "Ignoring messages from senders whose identity doesn't match \"%s\"\n", *optIdentity)
+		}
+		fmt.Fprintln(banner, "Use Ctrl-C to exit")
+		if colors != nil {
+			reporter.AddColoredOutput(os.Stdout, format, colors)
+		} else {
+			reporter.AddOutput(os.Stdout, format)
 		}
-		fmt.Println("Use Ctrl-C to exit")
-		reporter.AddOutput(os.Stdout)
 	}
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, syscall.SIGTERM, syscall.SIGINT, syscall.SIGTERM)
 
-	newswire := make(syslog.Channel, 10)
-	go reporter.Report(newswire, minSeverity)
+	newswire := syslog.NewQueue(*optQueueSize, overflow)
+	if *optRate > 0 {
+		newswire.LimitRate(syslog.NewRateLimiter(*optRate, *optRateBurst, *optRateByApp), *optRateReport)
+	}
+	go reporter.Report(newswire.Entries(), minSeverity)
 
-	udpListener, err := listener.NewUDPListener(*optPort, newswire)
-	CheckForFatalError(err)
-	go udpListener.Listen()
+	statsRequests := make(chan os.Signal, 1)
+	if len(statsSignals) > 0 {
+		signal.Notify(statsRequests, statsSignals...)
+	}
+	go func() {
+		for range statsRequests {
+			printStats(newswire, reporter, relay)
+		}
+	}()
 
-	tcpListener, err := listener.NewTCPListener(*optPort, newswire)
-	CheckForFatalError(err)
-	go tcpListener.Listen()
+	options := &listenOptions{udpReadBuffer: *optRcvBuf}
+	if hasTLS {
+		options.cert, err = listener.LoadCertificate(*optTLSCert, *optTLSKey, *optTLSGen)
+		CheckForFatalError(err)
+		if *optTLSCA != "" {
+			options.clientCAs, err = listener.LoadCertPool(*optTLSCA)
+			CheckForFatalError(err)
+		}
+	}
+
+	var closers []io.Closer
+	for _, a := range addresses {
+		closer, err := startListener(a, options, newswire)
+		if err != nil {
+			// Remove the sockets of the listeners which have started, as FatalError doesn't return
+			for _, c := range closers {
+				c.Close()
+			}
+			FatalError(err.Error())
+		}
+		if closer != nil {
+			closers = append(closers, closer)
+			defer closer.Close()
+		}
+	}
 
 	<-done // Wait
 
+	reporter.Stop()
+	printStats(newswire, reporter, relay)
 	if !*optQuiet {
-		fmt.Println(NAME, "Normal exit")
+		fmt.Fprintln(banner, NAME, "Normal exit")
+	}
+
+}
+
+// loadConfig sets the options which weren't given on the command line from the -config
+// file (if there is one), and returns the aliases in its [aliases] section
+func loadConfig() (*alias.Table, error) {
+	if *optConfig == "" {
+		return &alias.Table{}, nil
+	}
+	file, err := config.Load(*optConfig)
+	if err != nil {
+		return nil, err
+	}
+	table, err := configAliasTable(file)
+	errs := []error{err}
+	for _, s := range file.Settings {
+		switch name := s.FlagName(); {
+		case s.Section == "aliases":
+		case name == "config" || name == "check-config":
+			errs = append(errs, s.Errorf("%s can only be given on the command line", name))
+		case !onCommandLine[name]:
+			errs = append(errs, s.SetFlag(flag.CommandLine, checkOption))
+		}
+	}
+	return table, errors.Join(errs...)
+}
+
+// configAliasTable returns the aliases in the [aliases] section of a -config file
+func configAliasTable(file *config.File) (*alias.Table, error) {
+	table := &alias.Table{}
+	var errs []error
+	for _, s := range file.Settings {
+		if s.Section == "aliases" {
+			errs = append(errs, s.Wrap(table.Add(s.Key+" "+strings.Join(s.Values, " "))))
+		}
+	}
+	return table, errors.Join(errs...)
+}
+
+// reloadConfigAliases reads the [aliases] section of the -config file again. The other
+// options are only read at startup. If the file has errors, the previous aliases are kept.
+func reloadConfigAliases(aliases *alias.Current) error {
+	file, err := config.Load(*optConfig)
+	if err != nil {
+		return err
+	}
+	table, err := configAliasTable(file)
+	if err != nil {
+		return err
+	}
+	aliases.Store(table)
+	return nil
+}
+
+// checkOption checks the value of an option which is only used later, so that errors
+// in a -config file can be reported with their line numbers
+func checkOption(name, value string) error {
+	if value == "" {
+		return nil // The option's default
+	}
+	var err error
+	switch name {
+	case "severity":
+		_, err = severity.Parse(value)
+	case "facility", "exclude-facility":
+		_, err = compileFacilityFilter(value, false)
+	case "regex", "identity", "dedup-ignore", "include", "exclude", "highlight":
+		_, err = regexp.Compile(value)
+	case "filter":
+		_, err = filter.Compile(value)
+	case "source":
+		_, err = filter.ParseSourceRule(value)
+	case "match":
+		_, err = filter.ParseMatchTarget(value)
+	case "listen", "forward":
+		_, err = listener.ParseAddress(value)
+	case "forward-format":
+		_, err = forwarder.ParseFormat(value)
+	case "overflow":
+		_, err = syslog.ParseOverflowPolicy(value)
+	case "format", "file-format":
+		_, err = reporter.NewFormatter(value)
+	case "template", "file-template":
+		_, err = outputFormat("", value)
+	case "color":
+		_, err = reporter.UseColor(value, os.Stdout)
+	case "rotate-size":
+		_, err = logfile.ParseSize(value)
+	case "rotate-interval":
+		_, err = logfile.ParseInterval(value)
+	default:
+		err = checkLimit(name, value)
+	}
+	return err
+}
+
+// checkLimit checks that the value of a numeric option is in range. Values which
+// aren't numbers are left to the flag package to reject.
+func checkLimit(name, value string) error {
+	n, err := strconv.ParseFloat(value, 64)
+	if d, durationErr := time.ParseDuration(value); durationErr == nil {
+		n, err = d.Seconds(), nil
+	}
+	if err != nil {
+		return nil
+	}
+	switch name {
+	case "port":
+		if n < 1 || n > 65535 {
+			return errors.New("must be in the range 1..65535")
+		}
+	case "tls-port":
+		if n < 0 || n > 65535 {
+			return errors.New("must be in the range 0..65535 (0 to disable)")
+		}
+	case "udp-rcvbuf", "rotate-keep", "rate", "dedup":
+		if n < 0 {
+			return errors.New("must not be negative")
+		}
+	case "queue-size", "rate-burst", "dir-max-open":
+		if n < 1 {
+			return errors.New("must be at least 1")
+		}
+	case "rate-report", "resolve-ttl", "resolve-negative-ttl":
+		if n <= 0 {
+			return errors.New("must be positive")
+		}
+	}
+	return nil
+}
+
+// outputFormat returns a formatter using the template if there is one, otherwise the
+// named format
+func outputFormat(name, text string) (reporter.Formatter, error) {
+	if text == "" {
+		return reporter.NewFormatter(name)
+	}
+	t, err := reporter.NewTemplateFormat(text)
+	if err != nil {
+		return nil, err
+	}
+	return t, t.Check()
+}
+
+// rotateOptions returns how the -file output should be rotated
+func rotateOptions() logfile.Options {
+	var err error
+	options := logfile.Options{Pattern: *optRotatePattern, Keep: *optRotateKeep, Compress: *optRotateGzip}
+	if *optRotateSize != "" {
+		options.MaxSize, err = logfile.ParseSize(*optRotateSize)
+		CheckForFatalError(err)
+	}
+	options.Interval, err = logfile.ParseInterval(*optRotateEvery)
+	CheckForFatalError(err)
+	options.OnError = func(err error) {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", NAME, err)
+	}
+	return options
+}
+
+// loadFilter compiles the -filter, -facility, -exclude-facility and -filter-file
+// options into a single filter, returning nil if there are none
+func loadFilter() (*filter.Filter, error) {
+	var f *filter.Filter
+	if *optFilter != "" {
+		var err error
+		if f, err = filter.Compile(*optFilter); err != nil {
+			return nil, err
+		}
+	}
+	for _, option := range []struct {
+		list    string
+		exclude bool
+	}{{*optFacility, false}, {*optNoFacility, true}} {
+		if option.list == "" {
+			continue
+		}
+		facilityFilter, err := compileFacilityFilter(option.list, option.exclude)
+		if err != nil {
+			return nil, err
+		}
+		f = f.And(facilityFilter)
+	}
+	if *optFilterFile != "" {
+		text, err := os.ReadFile(*optFilterFile)
+		if err != nil {
+			return nil, err
+		}
+		fileFilter, err := filter.Compile(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", *optFilterFile, err)
+		}
+		f = f.And(fileFilter)
+	}
+	return f, nil
+}
+
+// compileFacilityFilter returns a filter selecting (or if exclude is true, excluding)
+// a comma separated list of facilities
+func compileFacilityFilter(list string, exclude bool) (*filter.Filter, error) {
+	var values []string
+	for _, s := range strings.Split(list, ",") {
+		f, err := facility.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, f.String())
+	}
+	expr := "facility in (" + strings.Join(values, ",") + ")"
+	if exclude {
+		expr = "!" + expr
+	}
+	return filter.Compile(expr)
+}
+
+// deduplicator returns a Deduplicator if -dedup was given
+func deduplicator() (*reporter.Deduplicator, error) {
+	if *optDedup == 0 {
+		if *optDedupNumbers || *optDedupIgnore != "" {
+			return nil, fmt.Errorf("-dedup-numbers and -dedup-ignore require -dedup")
+		}
+		return nil, nil
+	}
+	var ignore []string
+	if *optDedupNumbers {
+		ignore = append(ignore, reporter.Numbers.String())
+	}
+	if *optDedupIgnore != "" {
+		ignore = append(ignore, *optDedupIgnore)
+	}
+	var regex *regexp.Regexp
+	if len(ignore) > 0 {
+		var err error
+		if regex, err = regexp.Compile(strings.Join(ignore, "|")); err != nil {
+			return nil, fmt.Errorf("Invalid regular expression: %s", err)
+		}
+	}
+	return reporter.NewDeduplicator(*optDedup, regex), nil
+}
+
+// loadPatterns returns the -include and -exclude patterns
+func loadPatterns() (*filter.Patterns, error) {
+	target, err := filter.ParseMatchTarget(*optMatch)
+	if err != nil {
+		return nil, err
+	}
+	p := filter.NewPatterns(target, *optIgnoreCase)
+	for _, expr := range optExclude {
+		if err := p.Add(expr, true); err != nil {
+			return nil, err
+		}
 	}
+	if *optExcludeFile != "" {
+		if err := p.AddFile(*optExcludeFile, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, expr := range optInclude {
+		if err := p.Add(expr, false); err != nil {
+			return nil, err
+		}
+	}
+	if *optIncludeFile != "" {
+		if err := p.AddFile(*optIncludeFile, false); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
 
+// printStats writes the number of entries received, dropped, filtered, written and
+// forwarded to stderr
+func printStats(queue *syslog.Queue, r *reporter.Reporter, relay *forwarder.Forwarder) {
+	fmt.Fprintf(os.Stderr, "%s: received %d, dropped %d, filtered %d, written %d",
+		NAME, queue.Received(), queue.Dropped(), r.Filtered(), r.Written())
+	if *optRate > 0 {
+		fmt.Fprintf(os.Stderr, ", rate limited %d", queue.Suppressed())
+	}
+	if *optDedup > 0 {
+		fmt.Fprintf(os.Stderr, ", repeats suppressed %d", r.Suppressed())
+	}
+	if relay != nil {
+		fmt.Fprintf(os.Stderr, ", forwarded %d, spooled %d, not forwarded %d", relay.Forwarded(), relay.Spooled(), relay.Dropped())
+	}
+	fmt.Fprintln(os.Stderr)
+	for _, p := range r.Patterns().Patterns() {
+		kind := "include"
+		if p.Exclude() {
+			kind = "exclude"
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s %q: %d hits\n", NAME, kind, p.String(), p.Hits())
+	}
+}
+
+// startForwarder starts forwarding entries if -forward was given
+func startForwarder() *forwarder.Forwarder {
+	if *optForward == "" {
+		if *optForwardSpool != "" || *optForwardCA != "" || *optForwardCert != "" {
+			FatalError("The -forward options require -forward")
+		}
+		return nil
+	}
+	a, err := listener.ParseAddress(*optForward)
+	CheckForFatalError(err)
+	if host, _, _ := net.SplitHostPort(a.Address); host == "" {
+		FatalError("-forward must include a host name or address, e.g. udp://loghost")
+	}
+	options := forwarder.Options{Spool: *optForwardSpool}
+	options.Format, err = forwarder.ParseFormat(*optForwardFormat)
+	CheckForFatalError(err)
+	options.OnError = func(err error) {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", NAME, err)
+	}
+	if a.IsTLS() {
+		options.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		if *optForwardCA != "" {
+			options.TLS.RootCAs, err = listener.LoadCertPool(*optForwardCA)
+			CheckForFatalError(err)
+		}
+		if *optForwardCert != "" {
+			cert, err := listener.LoadCertificate(*optForwardCert, *optForwardKey, false)
+			CheckForFatalError(err)
+			options.TLS.Certificates = []tls.Certificate{cert}
+		}
+	} else if *optForwardCA != "" || *optForwardCert != "" {
+		FatalError("-forward-ca and -forward-cert require a tls:// -forward address")
+	}
+	relay, err := forwarder.New(a.Network(), a.Address, options)
+	CheckForFatalErrorF(err, "Could not forward to %s: %s", *optForward, err)
+	return relay
+}
+
+// listenAddresses returns the -listen addresses, or if there are none the addresses
+// given by -port, -tls-port and -unix
+func listenAddresses() []listener.Address {
+	var addresses []listener.Address
+	// -listen conflicts with the other options given in the same place, and options
+	// given on the command line replace those in the -config file
+	var conflicts []string
+	overridden := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port", "tls-port", "unix", "unix-stream":
+			if onCommandLine[f.Name] == onCommandLine["listen"] {
+				conflicts = append(conflicts, "-"+f.Name)
+			} else if onCommandLine[f.Name] {
+				overridden = true
+			}
+		}
+	})
+	if len(optListen) > 0 && !overridden {
+		if len(conflicts) > 0 {
+			FatalError("-listen replaces %s: give all the addresses with -listen instead", strings.Join(conflicts, ", "))
+		}
+		for _, s := range optListen {
+			a, err := listener.ParseAddress(s)
+			CheckForFatalError(err)
+			addresses = append(addresses, a)
+		}
+		return addresses
+	}
+	addresses = append(addresses,
+		listener.Address{Scheme: "udp", Address: fmt.Sprintf(":%d", *optPort)},
+		listener.Address{Scheme: "tcp", Address: fmt.Sprintf(":%d", *optPort)})
+	if *optTLSPort != 0 {
+		addresses = append(addresses, listener.Address{Scheme: "tls", Address: fmt.Sprintf(":%d", *optTLSPort)})
+	}
+	if *optUnix != "" {
+		if *optUnixStream {
+			addresses = append(addresses, listener.Address{Scheme: "unix", Address: *optUnix})
+		} else {
+			addresses = append(addresses, listener.Address{Scheme: "unixgram", Address: *optUnix})
+		}
+	}
+	return addresses
+}
+
+func joinAddresses(addresses []listener.Address) string {
+	s := make([]string, len(addresses))
+	for i, a := range addresses {
+		s[i] = a.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// Options shared by listeners
+type listenOptions struct {
+	cert          tls.Certificate // Used by TLS listeners
+	clientCAs     *x509.CertPool  // nil if TLS client certificates are not required
+	udpReadBuffer int             // SO_RCVBUF for UDP listeners, or 0 for the default
+}
+
+// startListener creates a listener for the address and starts it
+//
+// If the listener needs to be closed on exit, it is returned
+func startListener(a listener.Address, options *listenOptions, reporting *syslog.Queue) (io.Closer, error) {
+	switch {
+	case a.IsTLS():
+		l, err := listener.NewTLSListener(a.Network(), a.Address, options.cert, options.clientCAs, reporting)
+		if err != nil {
+			return nil, err
+		}
+		go l.Listen()
+	case a.Scheme == "unix" || a.Scheme == "unixgram":
+		l, err := listener.NewUnixListener(a.Scheme, a.Address, reporting)
+		if err != nil {
+			return nil, err
+		}
+		go l.Listen()
+		return l, nil
+	case strings.HasPrefix(a.Scheme, "udp"):
+		l, err := listener.NewUDPListener(a.Network(), a.Address, reporting)
+		if err != nil {
+			return nil, err
+		}
+		if options.udpReadBuffer > 0 {
+			if err := l.SetReadBuffer(options.udpReadBuffer); err != nil {
+				return nil, fmt.Errorf("Could not set UDP receive buffer: %s", err)
+			}
+		}
+		go l.Listen()
+	default:
+		l, err := listener.NewTCPListener(a.Network(), a.Address, reporting)
+		if err != nil {
+			return nil, err
+		}
+		go l.Listen()
+	}
+	return nil, nil
 }